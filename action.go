@@ -16,99 +16,538 @@ package main
 
 import (
 	"context"
+	"crypto"
+	"encoding/json"
 	"fmt"
+	"io"
+	"sort"
+	"strconv"
 	"strings"
+	"time"
 
-	"github.com/google/go-github/v35/github"
 	collector "github.com/rode/collector-build/proto/v1alpha1"
+	rode "github.com/rode/create-build-occurrence-action/proto/rode/v1alpha1"
 	"go.uber.org/zap"
+	"google.golang.org/protobuf/encoding/protojson"
 	"google.golang.org/protobuf/types/known/timestamppb"
 )
 
-//go:generate counterfeiter -o mocks/actions_service.go . actionsService
-type actionsService interface {
-	ListWorkflowJobs(ctx context.Context, owner, repo string, runID int64, opts *github.ListWorkflowJobsOptions) (*github.Jobs, *github.Response, error)
+type createBuildOccurrenceAction struct {
+	// annotations is where policy violations are written as workflow commands, usually stdout
+	annotations io.Writer
+	config      *config
+	collectors  []*buildCollector
+	logger      *zap.Logger
+	metrics     *buildMetrics
+	outputs     actionOutputs
+	policy      rode.RodeClient
+	provider    ciProvider
+	resolver    digestResolver
+	signer      crypto.Signer
 }
 
-type createBuildOccurrenceAction struct {
-	actions actionsService
-	config  *config
-	client  collector.BuildCollectorClient
-	logger  *zap.Logger
+// actionOutputs holds step outputs, other than the occurrence id, that are set while the action runs
+type actionOutputs map[string]string
+
+func (o actionOutputs) names() []string {
+	var names []string
+	for name := range o {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+func (a *createBuildOccurrenceAction) setOutput(name, value string) {
+	if a.outputs == nil {
+		a.outputs = actionOutputs{}
+	}
+
+	a.outputs[name] = value
 }
 
 func (a *createBuildOccurrenceAction) Run(ctx context.Context) (string, error) {
-	owner, repo := getRepoAndOwnerFromSlug(a.config.GitHub.RepoSlug)
-	a.logger.Info("Fetching jobs for workflow")
-	jobs, _, err := a.actions.ListWorkflowJobs(ctx, owner, repo, a.config.GitHub.RunId, &github.ListWorkflowJobsOptions{})
+	a.logger.Info("Fetching build details from the CI provider")
+	a.metrics.enter(stageCIProvider)
+	started := time.Now()
+	build, err := a.provider.BuildMetadata(ctx)
+	a.metrics.observeCILookup(time.Since(started))
 	if err != nil {
-		return "", fmt.Errorf("error listing jobs: %s", err)
+		return "", describeTimeout(ctx, err, a.config.Timeout)
+	}
+	for _, warning := range build.Warnings {
+		a.logger.Warn(warning)
 	}
 
-	var job *github.WorkflowJob
-	for _, j := range jobs.Jobs {
-		if j.GetName() == a.config.GitHub.JobId {
-			job = j
-			break
-		}
+	if err := a.overrideBuildTiming(build); err != nil {
+		return "", err
 	}
 
-	if job == nil {
-		return "", fmt.Errorf("unable to find job with id %s", a.config.GitHub.JobId)
+	if err := a.resolveCreator(build); err != nil {
+		return "", err
 	}
 
-	repoUri := fmt.Sprintf("%s/%s", a.config.GitHub.ServerUrl, a.config.GitHub.RepoSlug)
-	commitUri := fmt.Sprintf("%s/commit/%s", repoUri, a.config.GitHub.CommitId)
-	logsUri := fmt.Sprintf("%s/checks/%d/logs", commitUri, job.GetID())
-	artifact := buildArtifact(a.config)
+	a.metrics.enter(stageCommitVerification)
+	if err := a.checkVerification(build); err != nil {
+		return "", err
+	}
+
+	if trigger := build.Trigger; trigger != nil {
+		a.setOutput("eventName", trigger.Event)
+		a.setOutput("ref", trigger.Ref)
+		a.setOutput("triggeringActor", trigger.TriggeringActor)
+		a.setOutput("workflow", trigger.Workflow)
+		a.setOutput("workflowRef", trigger.WorkflowRef)
+	}
+
+	if pullRequest := build.PullRequest; pullRequest != nil {
+		a.setOutput("pullRequestNumber", strconv.Itoa(pullRequest.Number))
+		a.setOutput("pullRequestUrl", pullRequest.Url)
+		a.setOutput("headRef", pullRequest.HeadRef)
+		a.setOutput("headSha", pullRequest.HeadSha)
+		a.setOutput("baseRef", pullRequest.BaseRef)
+	}
+
+	a.metrics.enter(stageArtifact)
+	artifact, err := buildArtifact(a.config)
+	if err != nil {
+		return "", fmt.Errorf("error building artifact: %s", err)
+	}
+
+	if a.resolver != nil {
+		if err := a.resolveArtifactDigest(ctx, artifact); err != nil {
+			return "", fmt.Errorf("error resolving artifact digest: %s", err)
+		}
+	}
+
+	artifacts := []*collector.Artifact{artifact}
+	if a.config.SbomPaths != "" {
+		sbomArtifacts, err := a.linkSboms(build.StepSummary)
+		if err != nil {
+			return "", fmt.Errorf("error linking SBOMs: %s", err)
+		}
+		artifacts = append(artifacts, sbomArtifacts...)
+	}
 
 	request := &collector.CreateBuildRequest{
-		Artifacts:    []*collector.Artifact{artifact},
-		BuildStart:   timestamppb.New(job.GetStartedAt().Time),
-		BuildEnd:     timestamppb.Now(),
-		CommitId:     a.config.GitHub.CommitId,
-		CommitUri:    commitUri,
-		Creator:      a.config.GitHub.Actor,
-		LogsUri:      logsUri,
-		ProvenanceId: job.GetHTMLURL(),
-		Repository:   repoUri,
+		Artifacts:    artifacts,
+		BuildStart:   timestamppb.New(build.BuildStart),
+		BuildEnd:     timestamppb.New(build.BuildEnd),
+		CommitId:     build.CommitId,
+		CommitUri:    build.CommitUri,
+		Creator:      build.Actor,
+		LogsUri:      build.LogsUri,
+		ProvenanceId: build.ProvenanceId,
+		Repository:   build.Repository,
+	}
+
+	var provenance []byte
+	if a.config.Provenance != nil && a.config.Provenance.Path != "" {
+		provenance, err = a.writeProvenance(request, build.Invocation)
+		if err != nil {
+			return "", fmt.Errorf("error generating provenance: %s", err)
+		}
 	}
 
+	if a.signer != nil {
+		if err := a.writeEnvelope(request, provenance); err != nil {
+			return "", fmt.Errorf("error signing build metadata: %s", err)
+		}
+	}
+
+	if a.config.RequestPath != "" {
+		if err := a.saveRequest(request); err != nil {
+			return "", fmt.Errorf("error saving request: %s", err)
+		}
+	}
+
+	return a.Replay(ctx, request)
+}
+
+// Replay sends a request that has already been assembled, e.g. one that was saved by a previous run, then evaluates the
+// configured artifact against any policies. That's always the first artifact in the request, the rest are the SBOMs and
+// provenance that describe it, which aren't Rode resources
+func (a *createBuildOccurrenceAction) Replay(ctx context.Context, request *collector.CreateBuildRequest) (string, error) {
 	a.logger.Info("Sending request to build collector")
-	response, err := a.client.CreateBuild(ctx, request)
+	a.metrics.enter(stageBuildCollector)
+	started := time.Now()
+	results := a.fanOut(ctx, func(ctx context.Context, c *buildCollector) (string, error) {
+		response, err := c.client.CreateBuild(ctx, request)
+		if err != nil {
+			return "", err
+		}
+
+		a.logger.Info(fmt.Sprintf("Successfully created build occurrence%s, id is %s", a.describe(c), response.BuildOccurrenceId))
+
+		return response.BuildOccurrenceId, nil
+	})
+	a.metrics.observeCreateBuild(time.Since(started))
+
+	id, err := a.collectResults(ctx, results, "creating build occurrence")
+	if err != nil || a.policy == nil || len(request.Artifacts) == 0 {
+		return id, err
+	}
+
+	// the build occurrence exists whatever the policy says, so it's counted as created
+	a.metrics.record(ctx, nil)
+
+	return id, a.evaluatePolicies(ctx, request.Artifacts[0])
+}
+
+// UpdateArtifacts adds the configured artifact to the build occurrence that produced the existing artifact
+func (a *createBuildOccurrenceAction) UpdateArtifacts(ctx context.Context, existingArtifactId string) (string, error) {
+	artifact, err := buildArtifact(a.config)
+	if err != nil {
+		return "", fmt.Errorf("error building artifact: %s", err)
+	}
+
+	if a.resolver != nil {
+		if err := a.resolveArtifactDigest(ctx, artifact); err != nil {
+			return "", fmt.Errorf("error resolving artifact digest: %s", err)
+		}
+	}
+
+	a.logger.Info(fmt.Sprintf("Adding %s to the build occurrence for %s", artifact.Id, existingArtifactId))
+	results := a.fanOut(ctx, func(ctx context.Context, c *buildCollector) (string, error) {
+		response, err := c.client.UpdateBuildArtifacts(ctx, &collector.UpdateBuildArtifactsRequest{
+			ExistingArtifactId: existingArtifactId,
+			NewArtifact:        artifact,
+		})
+		if err != nil {
+			return "", err
+		}
+
+		return response.BuildOccurrenceId, nil
+	})
+
+	return a.collectResults(ctx, results, "updating build artifacts")
+}
+
+func (a *createBuildOccurrenceAction) saveRequest(request *collector.CreateBuildRequest) error {
+	contents, err := protojson.MarshalOptions{Multiline: true}.Marshal(request)
 	if err != nil {
-		return "", fmt.Errorf("error creating build occurrence: %s", err)
+		return err
 	}
 
-	a.logger.Info(fmt.Sprintf("Successfully created build occurrence, id is %s", response.BuildOccurrenceId))
+	a.logger.Info(fmt.Sprintf("Saving request to %s", a.config.RequestPath))
 
-	return response.BuildOccurrenceId, nil
+	return writeFile(a.config.RequestPath, contents)
 }
 
-func getRepoAndOwnerFromSlug(slug string) (string, string) {
-	parts := strings.Split(slug, "/")
+// checkVerification sets the outputs for the commit signature, and refuses unsigned commits when they're required
+func (a *createBuildOccurrenceAction) checkVerification(build *buildMetadata) error {
+	if build.Verification == nil {
+		if a.config.RequireSignedCommit {
+			return fmt.Errorf("the signature of commit %s can't be verified in this CI system", build.CommitId)
+		}
+
+		return nil
+	}
+
+	a.setOutput("commitVerified", strconv.FormatBool(build.Verification.Verified))
+	a.setOutput("commitVerificationReason", build.Verification.Reason)
+	a.setOutput("commitSigner", build.Verification.Signer)
 
-	return parts[0], parts[1]
+	if build.Verification.Verified {
+		a.logger.Info(fmt.Sprintf("Commit %s is signed by %s", build.CommitId, build.Verification.Signer))
+		return nil
+	}
+
+	if a.config.RequireSignedCommit {
+		return fmt.Errorf("commit %s does not have a verified signature: %s", build.CommitId, build.Verification.Reason)
+	}
+	a.logger.Warn(fmt.Sprintf("Commit %s does not have a verified signature: %s", build.CommitId, build.Verification.Reason))
+
+	return nil
 }
 
-func buildArtifact(c *config) *collector.Artifact {
+// resolveCreator renders the creator template if there is one, and records where the creator came from
+func (a *createBuildOccurrenceAction) resolveCreator(build *buildMetadata) error {
+	source := a.config.CreatorSource
+	if source == "" {
+		source = creatorSourceActor
+	}
+
+	if source == creatorSourceTemplate {
+		creator, err := renderCreator(a.config.CreatorTemplate, build)
+		if err != nil {
+			return fmt.Errorf("error rendering creator: %s", err)
+		}
+		build.Actor = creator
+	}
+
+	if build.Invocation != nil {
+		build.Invocation.Environment["creator_source"] = source
+	}
+	a.setOutput("creator", build.Actor)
+	a.setOutput("creatorSource", source)
+
+	return nil
+}
+
+// overrideBuildTiming replaces the times from the CI provider with any that were set explicitly
+func (a *createBuildOccurrenceAction) overrideBuildTiming(build *buildMetadata) error {
+	if a.config.BuildStart != "" {
+		buildStart, err := time.Parse(time.RFC3339, a.config.BuildStart)
+		if err != nil {
+			return fmt.Errorf("invalid build start: %s", err)
+		}
+		build.BuildStart = buildStart
+	}
+
+	if a.config.BuildEnd != "" {
+		buildEnd, err := time.Parse(time.RFC3339, a.config.BuildEnd)
+		if err != nil {
+			return fmt.Errorf("invalid build end: %s", err)
+		}
+		build.BuildEnd = buildEnd
+	}
+
+	if build.BuildStart.After(build.BuildEnd) {
+		a.logger.Warn(fmt.Sprintf("The build start %s is after the build end %s", build.BuildStart.Format(time.RFC3339), build.BuildEnd.Format(time.RFC3339)))
+	}
+
+	return nil
+}
+
+// linkSboms parses each SBOM, and returns artifacts that identify them by digest so that they're associated with the build
+func (a *createBuildOccurrenceAction) linkSboms(stepSummary string) ([]*collector.Artifact, error) {
+	paths, err := findSboms(splitList(a.config.SbomPaths, a.config.ArtifactNamesDelimiter))
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		artifacts  []*collector.Artifact
+		documents  []*sbomDocument
+		components int
+	)
+	for _, path := range paths {
+		document, err := parseSbom(path)
+		if err != nil {
+			return nil, err
+		}
+
+		a.logger.Info(fmt.Sprintf("Found %s %s SBOM %s with %d components", document.Format, document.SpecVersion, path, document.Components))
+		if document.Components == 0 {
+			a.logger.Warn(fmt.Sprintf("SBOM %s does not list any components", path))
+		}
+
+		documents = append(documents, document)
+		components += document.Components
+		artifacts = append(artifacts, fileArtifact(path, document.Digest))
+	}
+
+	a.setOutput("sbomComponentCount", strconv.Itoa(components))
+
+	if stepSummary != "" {
+		if err := appendFile(stepSummary, []byte(sbomSummary(documents))); err != nil {
+			return nil, fmt.Errorf("error writing job summary: %s", err)
+		}
+	}
+
+	return artifacts, nil
+}
+
+// resolveArtifactDigest replaces a mutable tag reference with the digest that it currently points to.
+// The tag reference is kept as one of the artifact names.
+func (a *createBuildOccurrenceAction) resolveArtifactDigest(ctx context.Context, artifact *collector.Artifact) error {
+	if isPackageURL(artifact.Id) {
+		return nil
+	}
+
+	reference, err := parseImageReference(artifact.Id)
+	if err != nil {
+		return err
+	}
+
+	if reference.Digest != "" {
+		return nil
+	}
+
+	a.logger.Info(fmt.Sprintf("Resolving digest for %s", artifact.Id))
+	digest, err := a.resolver.ResolveDigest(ctx, artifact.Id)
+	if err != nil {
+		return err
+	}
+
+	tagReference := artifact.Id
+	artifact.Id = fmt.Sprintf("%s@%s", reference.Name, digest)
+
+	for _, name := range artifact.Names {
+		if name == tagReference {
+			return nil
+		}
+	}
+	artifact.Names = append([]string{tagReference}, artifact.Names...)
+
+	return nil
+}
+
+func (a *createBuildOccurrenceAction) writeProvenance(request *collector.CreateBuildRequest, invocation *buildInvocation) ([]byte, error) {
+	path := a.config.Provenance.Path
+	a.logger.Info(fmt.Sprintf("Writing provenance to %s", path))
+
+	statement, err := newProvenanceStatement(a.config.Provenance.Version, request, invocation)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(statement.Subject) == 0 {
+		a.logger.Warn("None of the artifacts are identified by a sha256 digest, the provenance will not have any subjects")
+	}
+
+	contents, err := json.Marshal(statement)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := writeFile(path, contents); err != nil {
+		return nil, err
+	}
+
+	digest := sha256Digest(contents)
+	a.setOutput("provenancePath", path)
+	a.setOutput("provenanceDigest", "sha256:"+digest)
+
+	if a.config.Provenance.Attach {
+		request.Artifacts = append(request.Artifacts, fileArtifact(path, digest))
+	}
+
+	return contents, nil
+}
+
+// writeEnvelope signs the provenance statement when there is one, otherwise the request sent to the build collector
+func (a *createBuildOccurrenceAction) writeEnvelope(request *collector.CreateBuildRequest, provenance []byte) error {
+	payloadType := inTotoPayloadType
+	payload := provenance
+	if payload == nil {
+		contents, err := protojson.Marshal(request)
+		if err != nil {
+			return err
+		}
+
+		payloadType = buildRequestPayloadType
+		payload = contents
+	}
+
+	envelope, err := signEnvelope(a.signer, payloadType, payload)
+	if err != nil {
+		return err
+	}
+
+	contents, err := json.Marshal(envelope)
+	if err != nil {
+		return err
+	}
+
+	path := a.config.Signing.EnvelopePath
+	a.logger.Info(fmt.Sprintf("Writing signed envelope to %s", path))
+	if err := writeFile(path, contents); err != nil {
+		return err
+	}
+
+	a.setOutput("envelopePath", path)
+	a.setOutput("signingKeyFingerprint", envelope.Signatures[0].KeyId)
+
+	return nil
+}
+
+func buildArtifact(c *config) (*collector.Artifact, error) {
+	var purl *packageURL
+	switch {
+	case c.ArtifactType != "" && c.ArtifactId != "":
+		return nil, fmt.Errorf("artifactId and artifactType are mutually exclusive")
+	case c.ArtifactType != "":
+		qualifiers, err := parseQualifiers(c.ArtifactQualifiers)
+		if err != nil {
+			return nil, err
+		}
+
+		purl = &packageURL{
+			Type:       c.ArtifactType,
+			Namespace:  c.ArtifactNamespace,
+			Name:       c.ArtifactName,
+			Version:    c.ArtifactVersion,
+			Qualifiers: qualifiers,
+		}
+		if err := purl.normalize(); err != nil {
+			return nil, err
+		}
+	case isPackageURL(c.ArtifactId):
+		p, err := parsePackageURL(c.ArtifactId)
+		if err != nil {
+			return nil, err
+		}
+		purl = p
+	case c.ArtifactId == "":
+		return nil, fmt.Errorf("either artifactId or artifactType is required")
+	}
+
 	artifact := &collector.Artifact{
 		Id: c.ArtifactId,
 	}
+	if purl != nil {
+		artifact.Id = purl.String()
+	}
 
 	if len(c.ArtifactNames) == 0 {
-		return artifact
+		return artifact, nil
 	}
 
-	names := strings.Split(c.ArtifactNames, c.ArtifactNamesDelimiter)
-	for _, name := range names {
-		name = strings.TrimSpace(name)
-		if len(name) == 0 {
-			continue
+	for _, name := range splitList(c.ArtifactNames, c.ArtifactNamesDelimiter) {
+		if isPackageURL(name) {
+			p, err := parsePackageURL(name)
+			if err != nil {
+				return nil, err
+			}
+			name = p.String()
+		} else if c.ArtifactType != "" {
+			// names for a package are treated as alternate versions of the same package
+			name = purl.withVersion(name).String()
 		}
 
 		artifact.Names = append(artifact.Names, name)
 	}
 
-	return artifact
+	return artifact, nil
+}
+
+// splitList separates the value by the delimiter, dropping any empty entries
+func splitList(value, delimiter string) []string {
+	var items []string
+	for _, item := range strings.Split(value, delimiter) {
+		item = strings.TrimSpace(item)
+		if len(item) == 0 {
+			continue
+		}
+
+		items = append(items, item)
+	}
+
+	return items
+}
+
+func parseQualifiers(raw string) (map[string]string, error) {
+	qualifiers := map[string]string{}
+	pairs := strings.FieldsFunc(raw, func(r rune) bool {
+		return r == ',' || r == '\n'
+	})
+
+	for _, pair := range pairs {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+
+		kv := strings.SplitN(pair, "=", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("qualifier %q must be in the form key=value", pair)
+		}
+
+		qualifiers[kv[0]] = kv[1]
+	}
+
+	return qualifiers, nil
 }
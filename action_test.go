@@ -16,8 +16,12 @@ package main
 
 import (
 	"context"
+	"crypto/ecdsa"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"os"
+	"path/filepath"
 	"strings"
 	"time"
 
@@ -26,14 +30,23 @@ import (
 	. "github.com/onsi/gomega"
 	collector "github.com/rode/collector-build/proto/v1alpha1"
 	"github.com/rode/create-build-occurrence-action/mocks"
+	rode "github.com/rode/create-build-occurrence-action/proto/rode/v1alpha1"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
 )
 
 var _ = Describe("createBuildOccurrenceAction", func() {
 	var (
 		ctx            context.Context
 		actionsService *mocks.FakeActionsService
+		gitService     *mocks.FakeGitService
+		usersService   *mocks.FakeUsersService
 		client         *mocks.FakeBuildCollectorClient
+		resolver       *mocks.FakeDigestResolver
 		conf           *config
+		githubConf     *githubConfig
 		action         *createBuildOccurrenceAction
 	)
 
@@ -44,24 +57,41 @@ var _ = Describe("createBuildOccurrenceAction", func() {
 			BuildCollector: &buildCollectorConfig{
 				Host: fake.URL(),
 			},
-			GitHub: &githubConfig{
-				Actor:     fake.Email(),
-				CommitId:  fake.LetterN(10),
-				JobId:     fake.Word(),
-				RepoSlug:  strings.Join([]string{fake.Word(), fake.Word()}, "/"),
-				RunId:     fake.Int64(),
-				ServerUrl: fake.URL(),
-				Token:     fake.LetterN(10),
+			Provenance: &provenanceConfig{
+				Version: slsaProvenanceV02,
 			},
+			Signing: &signingConfig{
+				EnvelopePath: "build.dsse.json",
+			},
+			Timeout: &timeoutConfig{},
+			Tracing: &tracingConfig{},
+		}
+		githubConf = &githubConfig{
+			Actor:     fake.Email(),
+			CommitId:  fake.LetterN(10),
+			JobId:     fake.Word(),
+			RepoSlug:  strings.Join([]string{fake.Word(), fake.Word()}, "/"),
+			RunId:     fake.Int64(),
+			ServerUrl: fake.URL(),
+			Token:     fake.LetterN(10),
 		}
 		client = &mocks.FakeBuildCollectorClient{}
 		actionsService = &mocks.FakeActionsService{}
+		gitService = &mocks.FakeGitService{}
+		usersService = &mocks.FakeUsersService{}
+		resolver = &mocks.FakeDigestResolver{}
 
 		action = &createBuildOccurrenceAction{
-			actions: actionsService,
-			client:  client,
-			config:  conf,
-			logger:  logger,
+			collectors: []*buildCollector{{name: defaultCollectorName, client: client}},
+			config:     conf,
+			logger:     logger,
+			provider: &githubProvider{
+				actions: actionsService,
+				config:  githubConf,
+				git:     gitService,
+				runner:  &runnerConfig{},
+				users:   usersService,
+			},
 		}
 	})
 
@@ -89,9 +119,9 @@ var _ = Describe("createBuildOccurrenceAction", func() {
 				expectedJobHtmlUrl = fake.URL()
 				expectedJobStartedAt = time.Now().UTC().Add(time.Duration(fake.Number(1, 5)) * time.Minute)
 
-				conf.GitHub.ServerUrl = "https://github.com"
-				conf.GitHub.RepoSlug = "rode/create-build-occurrence-action"
-				conf.GitHub.CommitId = "foobar"
+				githubConf.ServerUrl = "https://github.com"
+				githubConf.RepoSlug = "rode/create-build-occurrence-action"
+				githubConf.CommitId = "foobar"
 
 				jobs := &github.Jobs{
 					Jobs: []*github.WorkflowJob{
@@ -99,7 +129,7 @@ var _ = Describe("createBuildOccurrenceAction", func() {
 							ID:        github.Int64(expectedNumericJobId),
 							HTMLURL:   github.String(expectedJobHtmlUrl),
 							StartedAt: &github.Timestamp{Time: expectedJobStartedAt},
-							Name:      github.String(conf.GitHub.JobId),
+							Name:      github.String(githubConf.JobId),
 						},
 					},
 				}
@@ -117,7 +147,7 @@ var _ = Describe("createBuildOccurrenceAction", func() {
 
 				Expect(actualOwner).To(Equal("rode"))
 				Expect(actualRepo).To(Equal("create-build-occurrence-action"))
-				Expect(actualRunId).To(Equal(conf.GitHub.RunId))
+				Expect(actualRunId).To(Equal(githubConf.RunId))
 			})
 
 			It("should send a request to the build collector with the correct links", func() {
@@ -138,8 +168,8 @@ var _ = Describe("createBuildOccurrenceAction", func() {
 
 				Expect(actualRequest.Artifacts).To(HaveLen(1))
 				Expect(actualRequest.Artifacts[0].Id).To(Equal(conf.ArtifactId))
-				Expect(actualRequest.Creator).To(Equal(conf.GitHub.Actor))
-				Expect(actualRequest.CommitId).To(Equal(conf.GitHub.CommitId))
+				Expect(actualRequest.Creator).To(Equal(githubConf.Actor))
+				Expect(actualRequest.CommitId).To(Equal(githubConf.CommitId))
 				Expect(actualRequest.BuildStart.AsTime()).To(Equal(expectedJobStartedAt))
 				Expect(actualRequest.BuildEnd).NotTo(BeNil())
 			})
@@ -152,6 +182,18 @@ var _ = Describe("createBuildOccurrenceAction", func() {
 				Expect(actualError).NotTo(HaveOccurred())
 			})
 
+			When("metrics are collected", func() {
+				BeforeEach(func() {
+					action.metrics = newBuildMetrics()
+				})
+
+				It("should time the CI lookup and the request to the build collector", func() {
+					Expect(action.metrics.stage).To(Equal(stageBuildCollector))
+					Expect(histogramCount(action.metrics.ciLookup)).To(Equal(uint64(1)))
+					Expect(histogramCount(action.metrics.createBuild)).To(Equal(uint64(1)))
+				})
+			})
+
 			When("there are additional artifact names", func() {
 				var expectedArtifactNames []string
 
@@ -169,6 +211,555 @@ var _ = Describe("createBuildOccurrenceAction", func() {
 				})
 			})
 
+			When("build steps are named", func() {
+				var buildStart time.Time
+
+				BeforeEach(func() {
+					buildStart = time.Date(2021, 6, 1, 12, 30, 0, 0, time.UTC)
+					step := func(name string, start, end time.Duration) *github.TaskStep {
+						return &github.TaskStep{
+							Name:        github.String(name),
+							StartedAt:   &github.Timestamp{Time: buildStart.Add(start)},
+							CompletedAt: &github.Timestamp{Time: buildStart.Add(end)},
+						}
+					}
+
+					actionsService.ListWorkflowJobsReturns(&github.Jobs{
+						Jobs: []*github.WorkflowJob{
+							{
+								ID:        github.Int64(expectedNumericJobId),
+								StartedAt: &github.Timestamp{Time: expectedJobStartedAt},
+								Name:      github.String(githubConf.JobId),
+								Steps: []*github.TaskStep{
+									step("Checkout", -time.Minute, 0),
+									step("Test", 5*time.Minute, 10*time.Minute),
+									step("Build", 0, 5*time.Minute),
+									{Name: github.String("Create Build Occurrence"), StartedAt: &github.Timestamp{Time: buildStart.Add(10 * time.Minute)}},
+								},
+							},
+						},
+					}, nil, nil)
+					action.provider.(*githubProvider).buildSteps = []string{"Build", "Test"}
+				})
+
+				It("should use the timing of the steps", func() {
+					_, actualRequest, _ := client.CreateBuildArgsForCall(0)
+
+					Expect(actualRequest.BuildStart.AsTime()).To(Equal(buildStart))
+					Expect(actualRequest.BuildEnd.AsTime()).To(Equal(buildStart.Add(10 * time.Minute)))
+				})
+
+				When("a step doesn't exist", func() {
+					BeforeEach(func() {
+						action.provider.(*githubProvider).buildSteps = []string{"Deploy"}
+					})
+
+					It("should list the steps in the job", func() {
+						Expect(actualError).To(MatchError(ContainSubstring(`unable to find step "Deploy"`)))
+						Expect(actualError).To(MatchError(ContainSubstring(`"Checkout", "Test", "Build", "Create Build Occurrence"`)))
+						Expect(client.CreateBuildCallCount()).To(Equal(0))
+					})
+				})
+
+				When("a step hasn't completed", func() {
+					BeforeEach(func() {
+						action.provider.(*githubProvider).buildSteps = []string{"Create Build Occurrence"}
+					})
+
+					It("should return an error", func() {
+						Expect(actualError).To(MatchError(ContainSubstring(`step "Create Build Occurrence" has not completed`)))
+					})
+				})
+			})
+
+			It("should record the actor as the creator", func() {
+				Expect(action.outputs).To(HaveKeyWithValue("creator", githubConf.Actor))
+				Expect(action.outputs).To(HaveKeyWithValue("creatorSource", creatorSourceActor))
+				Expect(usersService.GetCallCount()).To(Equal(0))
+			})
+
+			It("should fetch the recorded commit", func() {
+				Expect(gitService.GetCommitCallCount()).To(Equal(1))
+
+				_, actualOwner, actualRepo, actualSha := gitService.GetCommitArgsForCall(0)
+				Expect([]string{actualOwner, actualRepo, actualSha}).To(Equal([]string{"rode", "create-build-occurrence-action", "foobar"}))
+			})
+
+			When("the commit has a verified signature", func() {
+				BeforeEach(func() {
+					gitService.GetCommitReturns(&github.Commit{
+						Committer:    &github.CommitAuthor{Email: github.String("jdoe@example.com")},
+						Verification: &github.SignatureVerification{Verified: github.Bool(true), Reason: github.String("valid")},
+					}, nil, nil)
+					conf.RequireSignedCommit = true
+				})
+
+				It("should set the verification outputs", func() {
+					Expect(actualError).NotTo(HaveOccurred())
+					Expect(action.outputs).To(HaveKeyWithValue("commitVerified", "true"))
+					Expect(action.outputs).To(HaveKeyWithValue("commitVerificationReason", "valid"))
+					Expect(action.outputs).To(HaveKeyWithValue("commitSigner", "jdoe@example.com"))
+				})
+
+				It("should record the verification in the provenance", func() {
+					build, err := action.provider.BuildMetadata(ctx)
+
+					Expect(err).NotTo(HaveOccurred())
+					Expect(build.Invocation.Environment).To(HaveKeyWithValue("github_commit_verified", "true"))
+					Expect(build.Invocation.Environment).To(HaveKeyWithValue("github_commit_verification_reason", "valid"))
+					Expect(build.Invocation.Environment).To(HaveKeyWithValue("github_commit_signer", "jdoe@example.com"))
+				})
+			})
+
+			When("the commit is unsigned", func() {
+				BeforeEach(func() {
+					gitService.GetCommitReturns(&github.Commit{
+						Committer:    &github.CommitAuthor{Email: github.String("jdoe@example.com")},
+						Verification: &github.SignatureVerification{Verified: github.Bool(false), Reason: github.String("unsigned")},
+					}, nil, nil)
+				})
+
+				It("should still create the build occurrence", func() {
+					Expect(actualError).NotTo(HaveOccurred())
+					Expect(action.outputs).To(HaveKeyWithValue("commitVerified", "false"))
+					Expect(action.outputs).To(HaveKeyWithValue("commitVerificationReason", "unsigned"))
+					Expect(action.outputs).To(HaveKeyWithValue("commitSigner", ""))
+				})
+
+				When("a signed commit is required", func() {
+					BeforeEach(func() {
+						conf.RequireSignedCommit = true
+					})
+
+					It("should refuse to create the build occurrence", func() {
+						Expect(actualError).To(MatchError("commit foobar does not have a verified signature: unsigned"))
+						Expect(client.CreateBuildCallCount()).To(Equal(0))
+					})
+				})
+			})
+
+			When("the commit can't be fetched", func() {
+				BeforeEach(func() {
+					gitService.GetCommitReturns(nil, nil, errors.New("not found"))
+				})
+
+				It("should still create the build occurrence without the verification outputs", func() {
+					Expect(actualError).NotTo(HaveOccurred())
+					Expect(client.CreateBuildCallCount()).To(Equal(1))
+					Expect(action.outputs).NotTo(HaveKey("commitVerified"))
+				})
+
+				It("should warn that the signature wasn't checked", func() {
+					build, err := action.provider.BuildMetadata(ctx)
+
+					Expect(err).NotTo(HaveOccurred())
+					Expect(build.Verification).To(BeNil())
+					Expect(build.Warnings).To(ConsistOf("Unable to check the signature of commit foobar: not found"))
+				})
+
+				When("a signed commit is required", func() {
+					BeforeEach(func() {
+						conf.RequireSignedCommit = true
+						action.provider.(*githubProvider).requireSignedCommit = true
+					})
+
+					It("should return an error", func() {
+						Expect(actualError).To(MatchError("error fetching commit foobar: not found"))
+					})
+				})
+
+				When("the creator is taken from the commit", func() {
+					BeforeEach(func() {
+						conf.CreatorSource = creatorSourceAuthor
+						action.provider.(*githubProvider).creatorSource = creatorSourceAuthor
+					})
+
+					It("should return an error", func() {
+						Expect(actualError).To(MatchError("error fetching commit foobar: not found"))
+					})
+				})
+			})
+
+			When("the creator is the actor's email", func() {
+				BeforeEach(func() {
+					conf.CreatorSource = creatorSourceEmail
+					action.provider.(*githubProvider).creatorSource = creatorSourceEmail
+					usersService.GetReturns(&github.User{
+						ID:    github.Int64(42),
+						Login: github.String(githubConf.Actor),
+						Email: github.String("jdoe@example.com"),
+					}, nil, nil)
+				})
+
+				It("should look up the user", func() {
+					Expect(usersService.GetCallCount()).To(Equal(1))
+					_, actualUser := usersService.GetArgsForCall(0)
+					Expect(actualUser).To(Equal(githubConf.Actor))
+
+					_, actualRequest, _ := client.CreateBuildArgsForCall(0)
+					Expect(actualRequest.Creator).To(Equal("jdoe@example.com"))
+				})
+
+				It("should record the creator source in the provenance", func() {
+					build, err := action.provider.BuildMetadata(ctx)
+					Expect(err).NotTo(HaveOccurred())
+					Expect(action.resolveCreator(build)).To(Succeed())
+
+					Expect(build.Invocation.Environment).To(HaveKeyWithValue("creator_source", creatorSourceEmail))
+				})
+
+				When("the user has no public email", func() {
+					BeforeEach(func() {
+						usersService.GetReturns(&github.User{ID: github.Int64(42), Login: github.String("jdoe")}, nil, nil)
+					})
+
+					It("should use the noreply address", func() {
+						_, actualRequest, _ := client.CreateBuildArgsForCall(0)
+
+						Expect(actualRequest.Creator).To(Equal("42+jdoe@users.noreply.github.com"))
+					})
+				})
+
+				When("the user can't be fetched", func() {
+					BeforeEach(func() {
+						usersService.GetReturns(nil, nil, errors.New(fake.Word()))
+					})
+
+					It("should return an error", func() {
+						Expect(actualError).To(MatchError(ContainSubstring("error fetching user")))
+						Expect(client.CreateBuildCallCount()).To(Equal(0))
+					})
+				})
+			})
+
+			When("the creator is the actor's id", func() {
+				BeforeEach(func() {
+					action.provider.(*githubProvider).creatorSource = creatorSourceId
+					usersService.GetReturns(&github.User{ID: github.Int64(42)}, nil, nil)
+				})
+
+				It("should use the user id", func() {
+					_, actualRequest, _ := client.CreateBuildArgsForCall(0)
+
+					Expect(actualRequest.Creator).To(Equal("42"))
+				})
+			})
+
+			When("the creator is from the commit", func() {
+				BeforeEach(func() {
+					gitService.GetCommitReturns(&github.Commit{
+						Author:    &github.CommitAuthor{Name: github.String("J Doe"), Email: github.String("jdoe@example.com")},
+						Committer: &github.CommitAuthor{Name: github.String("GitHub"), Email: github.String("noreply@github.com")},
+					}, nil, nil)
+				})
+
+				When("the author is chosen", func() {
+					BeforeEach(func() {
+						action.provider.(*githubProvider).creatorSource = creatorSourceAuthor
+					})
+
+					It("should use the author of the recorded commit", func() {
+						_, actualOwner, actualRepo, actualSha := gitService.GetCommitArgsForCall(0)
+						Expect([]string{actualOwner, actualRepo, actualSha}).To(Equal([]string{"rode", "create-build-occurrence-action", "foobar"}))
+
+						_, actualRequest, _ := client.CreateBuildArgsForCall(0)
+						Expect(actualRequest.Creator).To(Equal("jdoe@example.com"))
+					})
+				})
+
+				When("the committer is chosen", func() {
+					BeforeEach(func() {
+						action.provider.(*githubProvider).creatorSource = creatorSourceCommitter
+					})
+
+					It("should use the committer of the recorded commit", func() {
+						_, actualRequest, _ := client.CreateBuildArgsForCall(0)
+
+						Expect(actualRequest.Creator).To(Equal("noreply@github.com"))
+					})
+				})
+			})
+
+			When("the creator is a template", func() {
+				BeforeEach(func() {
+					conf.CreatorSource = creatorSourceTemplate
+					conf.CreatorTemplate = "{{.Actor}} via {{.Env.github_job}}"
+				})
+
+				It("should render the template", func() {
+					_, actualRequest, _ := client.CreateBuildArgsForCall(0)
+
+					Expect(actualRequest.Creator).To(Equal(githubConf.Actor + " via " + githubConf.JobId))
+					Expect(action.outputs).To(HaveKeyWithValue("creatorSource", creatorSourceTemplate))
+				})
+			})
+
+			When("the trigger is known", func() {
+				BeforeEach(func() {
+					githubConf.EventName = "workflow_dispatch"
+					githubConf.Ref = "refs/heads/main"
+					githubConf.TriggeringActor = "jdoe"
+					githubConf.Workflow = "release"
+					githubConf.WorkflowRef = "rode/create-build-occurrence-action/.github/workflows/release.yml@refs/heads/main"
+				})
+
+				It("should set the trigger outputs", func() {
+					Expect(action.outputs).To(HaveKeyWithValue("eventName", "workflow_dispatch"))
+					Expect(action.outputs).To(HaveKeyWithValue("ref", "refs/heads/main"))
+					Expect(action.outputs).To(HaveKeyWithValue("triggeringActor", "jdoe"))
+					Expect(action.outputs).To(HaveKeyWithValue("workflow", "release"))
+					Expect(action.outputs).To(HaveKeyWithValue("workflowRef", githubConf.WorkflowRef))
+				})
+
+				It("should record the trigger in the provenance", func() {
+					build, err := action.provider.BuildMetadata(ctx)
+
+					Expect(err).NotTo(HaveOccurred())
+					Expect(build.Invocation.EntryPoint).To(Equal(".github/workflows/release.yml"))
+					Expect(build.Invocation.Environment).To(HaveKeyWithValue("github_event_name", "workflow_dispatch"))
+					Expect(build.Invocation.Environment).To(HaveKeyWithValue("github_ref", "refs/heads/main"))
+					Expect(build.Invocation.Environment).To(HaveKeyWithValue("github_triggering_actor", "jdoe"))
+					Expect(build.Invocation.Environment).To(HaveKeyWithValue("github_workflow_ref", githubConf.WorkflowRef))
+				})
+			})
+
+			When("the workflow was triggered by a pull request", func() {
+				BeforeEach(func() {
+					githubConf.EventPath = filepath.Join(tempDir(), "event.json")
+					Expect(os.WriteFile(githubConf.EventPath, []byte(`{
+  "action": "synchronize",
+  "number": 12,
+  "pull_request": {
+    "number": 12,
+    "html_url": "https://github.com/rode/create-build-occurrence-action/pull/12",
+    "head": {"ref": "feature", "sha": "headsha"},
+    "base": {"ref": "main", "sha": "basesha"}
+  }
+}`), 0644)).To(Succeed())
+				})
+
+				It("should record the merge commit by default", func() {
+					_, actualRequest, _ := client.CreateBuildArgsForCall(0)
+
+					Expect(actualRequest.CommitId).To(Equal("foobar"))
+					Expect(actualRequest.CommitUri).To(Equal("https://github.com/rode/create-build-occurrence-action/commit/foobar"))
+				})
+
+				It("should set the pull request outputs", func() {
+					Expect(action.outputs).To(HaveKeyWithValue("pullRequestNumber", "12"))
+					Expect(action.outputs).To(HaveKeyWithValue("pullRequestUrl", "https://github.com/rode/create-build-occurrence-action/pull/12"))
+					Expect(action.outputs).To(HaveKeyWithValue("headRef", "feature"))
+					Expect(action.outputs).To(HaveKeyWithValue("headSha", "headsha"))
+					Expect(action.outputs).To(HaveKeyWithValue("baseRef", "main"))
+				})
+
+				It("should record the pull request in the provenance", func() {
+					build, err := action.provider.BuildMetadata(ctx)
+
+					Expect(err).NotTo(HaveOccurred())
+					Expect(build.Invocation.Environment).To(HaveKeyWithValue("github_sha", "foobar"))
+					Expect(build.Invocation.Environment).To(HaveKeyWithValue("github_pull_request_number", "12"))
+					Expect(build.Invocation.Environment).To(HaveKeyWithValue("github_pull_request_url", "https://github.com/rode/create-build-occurrence-action/pull/12"))
+					Expect(build.Invocation.Environment).To(HaveKeyWithValue("github_head_ref", "feature"))
+					Expect(build.Invocation.Environment).To(HaveKeyWithValue("github_head_sha", "headsha"))
+					Expect(build.Invocation.Environment).To(HaveKeyWithValue("github_base_ref", "main"))
+				})
+
+				When("the head commit is chosen", func() {
+					BeforeEach(func() {
+						action.provider.(*githubProvider).commitSource = commitSourceHead
+					})
+
+					It("should record the head of the pull request", func() {
+						_, actualRequest, _ := client.CreateBuildArgsForCall(0)
+
+						Expect(actualRequest.CommitId).To(Equal("headsha"))
+						Expect(actualRequest.CommitUri).To(Equal("https://github.com/rode/create-build-occurrence-action/commit/headsha"))
+					})
+				})
+			})
+
+			When("the workflow was triggered by a push", func() {
+				BeforeEach(func() {
+					githubConf.EventPath = filepath.Join(tempDir(), "event.json")
+					Expect(os.WriteFile(githubConf.EventPath, []byte(`{"ref": "refs/heads/main", "after": "foobar"}`), 0644)).To(Succeed())
+					action.provider.(*githubProvider).commitSource = commitSourceHead
+				})
+
+				It("should record GITHUB_SHA", func() {
+					_, actualRequest, _ := client.CreateBuildArgsForCall(0)
+
+					Expect(actualRequest.CommitId).To(Equal("foobar"))
+				})
+
+				It("should not set the pull request outputs", func() {
+					Expect(action.outputs).NotTo(HaveKey("pullRequestNumber"))
+					Expect(action.outputs).NotTo(HaveKey("headSha"))
+				})
+			})
+
+			When("the event can't be parsed", func() {
+				BeforeEach(func() {
+					githubConf.EventPath = filepath.Join(tempDir(), "event.json")
+					Expect(os.WriteFile(githubConf.EventPath, []byte(`{"pull_request": `), 0644)).To(Succeed())
+				})
+
+				It("should return an error", func() {
+					Expect(actualError).To(MatchError(ContainSubstring("error parsing event")))
+					Expect(client.CreateBuildCallCount()).To(Equal(0))
+				})
+			})
+
+			When("the scope is the workflow", func() {
+				var runStart time.Time
+
+				BeforeEach(func() {
+					runStart = time.Date(2021, 6, 1, 12, 30, 0, 0, time.UTC)
+					job := func(id int64, name string, start, end time.Duration) *github.WorkflowJob {
+						return &github.WorkflowJob{
+							ID:          github.Int64(id),
+							Name:        github.String(name),
+							StartedAt:   &github.Timestamp{Time: runStart.Add(start)},
+							CompletedAt: &github.Timestamp{Time: runStart.Add(end)},
+						}
+					}
+
+					actionsService.ListWorkflowJobsReturnsOnCall(0, &github.Jobs{
+						Jobs: []*github.WorkflowJob{
+							job(1, "compile", 0, 5*time.Minute),
+							job(2, "test", 5*time.Minute, 20*time.Minute),
+						},
+					}, &github.Response{NextPage: 2}, nil)
+					actionsService.ListWorkflowJobsReturnsOnCall(1, &github.Jobs{
+						Jobs: []*github.WorkflowJob{
+							job(3, "package", 6*time.Minute, 12*time.Minute),
+							{
+								ID:        github.Int64(expectedNumericJobId),
+								Name:      github.String(githubConf.JobId),
+								StartedAt: &github.Timestamp{Time: runStart.Add(21 * time.Minute)},
+							},
+							{
+								ID:   github.Int64(5),
+								Name: github.String("deploy"),
+							},
+						},
+					}, &github.Response{}, nil)
+
+					githubConf.ApiUrl = "https://api.github.com"
+					githubConf.RunId = 1234
+					githubConf.RunAttempt = "2"
+					action.provider.(*githubProvider).scope = scopeWorkflow
+				})
+
+				It("should fetch every page of jobs", func() {
+					Expect(actionsService.ListWorkflowJobsCallCount()).To(Equal(2))
+
+					_, _, _, _, actualOptions := actionsService.ListWorkflowJobsArgsForCall(1)
+					Expect(actualOptions.Page).To(Equal(2))
+				})
+
+				It("should use the timing of every other completed job in the run", func() {
+					_, actualRequest, _ := client.CreateBuildArgsForCall(0)
+
+					Expect(actualRequest.BuildStart.AsTime()).To(Equal(runStart))
+					Expect(actualRequest.BuildEnd.AsTime()).To(Equal(runStart.Add(20 * time.Minute)))
+				})
+
+				It("should skip the jobs that haven't completed with a warning", func() {
+					compile := &github.WorkflowJob{ID: github.Int64(1), Name: github.String("compile"), CompletedAt: &github.Timestamp{Time: runStart}}
+					current := &github.WorkflowJob{ID: github.Int64(expectedNumericJobId), Name: github.String(githubConf.JobId)}
+
+					selected, warnings, err := action.provider.(*githubProvider).selectJobs([]*github.WorkflowJob{
+						compile,
+						current,
+						{ID: github.Int64(5), Name: github.String("deploy")},
+					}, current)
+
+					Expect(err).NotTo(HaveOccurred())
+					Expect(selected).To(ConsistOf(compile))
+					Expect(warnings).To(ConsistOf(`Job "deploy" has not completed, so it isn't recorded as part of the build. Add it to the needs of this job to include it`))
+				})
+
+				It("should fail when none of the other jobs have completed", func() {
+					current := &github.WorkflowJob{ID: github.Int64(expectedNumericJobId), Name: github.String(githubConf.JobId)}
+
+					_, _, err := action.provider.(*githubProvider).selectJobs([]*github.WorkflowJob{
+						current,
+						{ID: github.Int64(5), Name: github.String("deploy")},
+					}, current)
+
+					Expect(err).To(MatchError(HavePrefix("none of the other jobs in the workflow run have completed")))
+				})
+
+				It("should link to the workflow run", func() {
+					_, actualRequest, _ := client.CreateBuildArgsForCall(0)
+
+					Expect(actualRequest.ProvenanceId).To(Equal("https://github.com/rode/create-build-occurrence-action/actions/runs/1234/attempts/2"))
+					Expect(actualRequest.LogsUri).To(Equal("https://api.github.com/repos/rode/create-build-occurrence-action/actions/runs/1234/attempts/2/logs"))
+				})
+
+				When("jobs are selected", func() {
+					BeforeEach(func() {
+						action.provider.(*githubProvider).workflowJobs = []string{"compile", "package"}
+					})
+
+					It("should only use the timing of those jobs", func() {
+						_, actualRequest, _ := client.CreateBuildArgsForCall(0)
+
+						Expect(actualRequest.BuildStart.AsTime()).To(Equal(runStart))
+						Expect(actualRequest.BuildEnd.AsTime()).To(Equal(runStart.Add(12 * time.Minute)))
+					})
+				})
+
+				When("a selected job doesn't exist", func() {
+					BeforeEach(func() {
+						action.provider.(*githubProvider).workflowJobs = []string{"release"}
+					})
+
+					It("should list the jobs in the run", func() {
+						Expect(actualError).To(MatchError(ContainSubstring(`unable to find job "release" in the workflow run, the jobs are "compile", "test", "package", "`)))
+					})
+				})
+
+				When("a selected job hasn't completed", func() {
+					BeforeEach(func() {
+						action.provider.(*githubProvider).workflowJobs = []string{"compile", githubConf.JobId}
+					})
+
+					It("should return an error", func() {
+						Expect(actualError).To(MatchError(ContainSubstring(fmt.Sprintf("job %q has not completed", githubConf.JobId))))
+						Expect(client.CreateBuildCallCount()).To(Equal(0))
+					})
+				})
+			})
+
+			When("the build timing is overridden", func() {
+				BeforeEach(func() {
+					conf.BuildStart = "2021-06-01T12:30:00Z"
+					conf.BuildEnd = "2021-06-01T12:45:00+01:00"
+				})
+
+				It("should use the explicit timestamps", func() {
+					_, actualRequest, _ := client.CreateBuildArgsForCall(0)
+
+					Expect(actualRequest.BuildStart.AsTime()).To(Equal(time.Date(2021, 6, 1, 12, 30, 0, 0, time.UTC)))
+					Expect(actualRequest.BuildEnd.AsTime()).To(Equal(time.Date(2021, 6, 1, 11, 45, 0, 0, time.UTC)))
+				})
+			})
+
+			When("only the build end is overridden", func() {
+				BeforeEach(func() {
+					conf.BuildEnd = "2021-06-01T12:45:00Z"
+				})
+
+				It("should keep the start time from the job", func() {
+					_, actualRequest, _ := client.CreateBuildArgsForCall(0)
+
+					Expect(actualRequest.BuildStart.AsTime()).To(Equal(expectedJobStartedAt))
+					Expect(actualRequest.BuildEnd.AsTime()).To(Equal(time.Date(2021, 6, 1, 12, 45, 0, 0, time.UTC)))
+				})
+			})
+
 			When("there is whitespace in the artifact names", func() {
 				BeforeEach(func() {
 					artifactNames := []string{fake.Word(), fake.Word() + "\n", ""}
@@ -183,6 +774,388 @@ var _ = Describe("createBuildOccurrenceAction", func() {
 					Expect(actualRequest.Artifacts[0].Names).To(HaveLen(2))
 				})
 			})
+
+			When("the artifact is described as a package", func() {
+				BeforeEach(func() {
+					conf.ArtifactId = ""
+					conf.ArtifactType = "npm"
+					conf.ArtifactNamespace = "@Rode"
+					conf.ArtifactName = "Demo-App"
+					conf.ArtifactVersion = "1.2.3"
+					conf.ArtifactQualifiers = "registry_url=npm.example.com, arch="
+					conf.ArtifactNamesDelimiter = "\n"
+					conf.ArtifactNames = "latest\npkg:NPM/%40rode/demo-app@next"
+				})
+
+				It("should use the canonical package url as the artifact id", func() {
+					_, actualRequest, _ := client.CreateBuildArgsForCall(0)
+
+					Expect(actualRequest.Artifacts[0].Id).To(Equal("pkg:npm/%40rode/demo-app@1.2.3?registry_url=npm.example.com"))
+				})
+
+				It("should derive package urls for the artifact names", func() {
+					_, actualRequest, _ := client.CreateBuildArgsForCall(0)
+
+					Expect(actualRequest.Artifacts[0].Names).To(ConsistOf(
+						"pkg:npm/%40rode/demo-app@latest?registry_url=npm.example.com",
+						"pkg:npm/%40rode/demo-app@next",
+					))
+				})
+			})
+
+			When("the artifact id is a package url", func() {
+				BeforeEach(func() {
+					conf.ArtifactId = "pkg:PyPI/Rode_Demo@1.0.0"
+				})
+
+				It("should normalize the package url", func() {
+					_, actualRequest, _ := client.CreateBuildArgsForCall(0)
+
+					Expect(actualRequest.Artifacts[0].Id).To(Equal("pkg:pypi/rode-demo@1.0.0"))
+				})
+			})
+
+			When("provenance is enabled", func() {
+				var provenancePath string
+
+				BeforeEach(func() {
+					provenancePath = filepath.Join(tempDir(), "provenance", "build.intoto.json")
+					conf.Provenance.Path = provenancePath
+					conf.ArtifactId = "harbor.example.com/rode/app@sha256:" + fake.LetterN(64)
+				})
+
+				It("should write the provenance statement", func() {
+					contents, err := os.ReadFile(provenancePath)
+					Expect(err).NotTo(HaveOccurred())
+
+					statement := map[string]interface{}{}
+					Expect(json.Unmarshal(contents, &statement)).To(Succeed())
+					Expect(statement["predicateType"]).To(Equal("https://slsa.dev/provenance/v0.2"))
+					Expect(statement["subject"]).To(HaveLen(1))
+				})
+
+				It("should set the provenance outputs", func() {
+					contents, err := os.ReadFile(provenancePath)
+					Expect(err).NotTo(HaveOccurred())
+
+					Expect(action.outputs).To(HaveKeyWithValue("provenancePath", provenancePath))
+					Expect(action.outputs).To(HaveKeyWithValue("provenanceDigest", "sha256:"+sha256Digest(contents)))
+				})
+
+				It("should not reference the provenance from the occurrence", func() {
+					_, actualRequest, _ := client.CreateBuildArgsForCall(0)
+
+					Expect(actualRequest.Artifacts).To(HaveLen(1))
+				})
+
+				When("the provenance should be attached", func() {
+					BeforeEach(func() {
+						conf.Provenance.Attach = true
+					})
+
+					It("should include the provenance digest in the occurrence", func() {
+						contents, err := os.ReadFile(provenancePath)
+						Expect(err).NotTo(HaveOccurred())
+						_, actualRequest, _ := client.CreateBuildArgsForCall(0)
+
+						Expect(actualRequest.Artifacts).To(HaveLen(2))
+						Expect(actualRequest.Artifacts[1].Id).To(Equal("build.intoto.json@sha256:" + sha256Digest(contents)))
+						Expect(actualRequest.Artifacts[1].Names).To(ConsistOf(provenancePath))
+					})
+				})
+
+				When("policies are evaluated", func() {
+					var (
+						policies   *policyServer
+						rodeServer *grpc.Server
+						conn       *grpc.ClientConn
+					)
+
+					BeforeEach(func() {
+						policies = &policyServer{
+							token:       fake.LetterN(10),
+							evaluations: map[string]map[string]*rode.EvaluatePolicyResponse{},
+							requests:    make(chan *rode.EvaluatePolicyRequest, 10),
+						}
+						var host string
+						rodeServer, host = startRode(policies)
+
+						conf.AccessToken = policies.token
+						conf.ArtifactNamesDelimiter = "\n"
+						conf.Provenance.Attach = true
+						conf.Policy = &policyConfig{Ids: "image-policy", RodeHost: host, RodeInsecure: true}
+						conf.Timeout.Dial = time.Second
+
+						var err error
+						conn, action.policy, err = newRodeClient(ctx, conf)
+						Expect(err).NotTo(HaveOccurred())
+					})
+
+					AfterEach(func() {
+						conn.Close()
+						rodeServer.Stop()
+					})
+
+					It("should only evaluate the artifact, not the attached provenance", func() {
+						Expect(actualError).NotTo(HaveOccurred())
+
+						var request *rode.EvaluatePolicyRequest
+						Expect(policies.requests).To(Receive(&request))
+						Expect(request.ResourceUri).To(Equal(conf.ArtifactId))
+						Expect(policies.requests).NotTo(Receive())
+					})
+				})
+
+				When("the provenance version is invalid", func() {
+					BeforeEach(func() {
+						conf.Provenance.Version = fake.Word()
+					})
+
+					It("should return an error", func() {
+						Expect(actualError).To(HaveOccurred())
+						Expect(actualError.Error()).To(ContainSubstring("error generating provenance"))
+						Expect(client.CreateBuildCallCount()).To(Equal(0))
+					})
+				})
+			})
+
+			When("a signing key is provided", func() {
+				var (
+					key          *ecdsa.PrivateKey
+					envelopePath string
+				)
+
+				BeforeEach(func() {
+					_, key = ecdsaKeyPem()
+					envelopePath = filepath.Join(tempDir(), "build.dsse.json")
+					conf.Signing.EnvelopePath = envelopePath
+					action.signer = key
+				})
+
+				readEnvelope := func() (*dsseEnvelope, []byte) {
+					contents, err := os.ReadFile(envelopePath)
+					Expect(err).NotTo(HaveOccurred())
+
+					envelope := &dsseEnvelope{}
+					Expect(json.Unmarshal(contents, envelope)).To(Succeed())
+					payload, err := verifyEnvelope(envelope, key.Public())
+					Expect(err).NotTo(HaveOccurred())
+
+					return envelope, payload
+				}
+
+				It("should sign the request sent to the build collector", func() {
+					envelope, payload := readEnvelope()
+					_, actualRequest, _ := client.CreateBuildArgsForCall(0)
+					signedRequest := &collector.CreateBuildRequest{}
+
+					Expect(envelope.PayloadType).To(Equal(buildRequestPayloadType))
+					Expect(protojson.Unmarshal(payload, signedRequest)).To(Succeed())
+					Expect(proto.Equal(signedRequest, actualRequest)).To(BeTrue())
+				})
+
+				It("should set the signing outputs", func() {
+					expectedFingerprint, err := keyFingerprint(key.Public())
+					Expect(err).NotTo(HaveOccurred())
+
+					Expect(action.outputs).To(HaveKeyWithValue("envelopePath", envelopePath))
+					Expect(action.outputs).To(HaveKeyWithValue("signingKeyFingerprint", expectedFingerprint))
+				})
+
+				When("provenance is enabled", func() {
+					BeforeEach(func() {
+						conf.Provenance.Path = filepath.Join(tempDir(), "build.intoto.json")
+					})
+
+					It("should sign the provenance statement", func() {
+						envelope, payload := readEnvelope()
+						provenance, err := os.ReadFile(conf.Provenance.Path)
+						Expect(err).NotTo(HaveOccurred())
+
+						Expect(envelope.PayloadType).To(Equal(inTotoPayloadType))
+						Expect(payload).To(Equal(provenance))
+					})
+				})
+			})
+
+			When("SBOMs are provided", func() {
+				var (
+					dir         string
+					summaryPath string
+				)
+
+				BeforeEach(func() {
+					dir = tempDir()
+					summaryPath = filepath.Join(dir, "summary.md")
+					writeSbom(dir, "app.spdx.json", spdxJSONSbom)
+					writeSbom(dir, "app.cdx.json", cycloneDXJSONSbom)
+
+					conf.ArtifactNamesDelimiter = "\n"
+					conf.SbomPaths = filepath.Join(dir, "*.json")
+					githubConf.StepSummary = summaryPath
+				})
+
+				It("should reference each SBOM by digest in the occurrence", func() {
+					_, actualRequest, _ := client.CreateBuildArgsForCall(0)
+
+					Expect(actualRequest.Artifacts).To(HaveLen(3))
+					Expect(actualRequest.Artifacts[1].Id).To(Equal("app.cdx.json@sha256:" + sha256Digest([]byte(cycloneDXJSONSbom))))
+					Expect(actualRequest.Artifacts[2].Id).To(Equal("app.spdx.json@sha256:" + sha256Digest([]byte(spdxJSONSbom))))
+				})
+
+				It("should output the total number of components", func() {
+					Expect(action.outputs).To(HaveKeyWithValue("sbomComponentCount", "5"))
+				})
+
+				It("should add the SBOMs to the job summary", func() {
+					summary, err := os.ReadFile(summaryPath)
+					Expect(err).NotTo(HaveOccurred())
+
+					Expect(string(summary)).To(ContainSubstring("| CycloneDX 1.3 | 3 |"))
+					Expect(string(summary)).To(ContainSubstring("| SPDX 2.2 | 2 |"))
+				})
+
+				When("an SBOM is invalid", func() {
+					BeforeEach(func() {
+						writeSbom(dir, "invalid.json", `{"foo": "bar"}`)
+					})
+
+					It("should return an error", func() {
+						Expect(actualError).To(MatchError(ContainSubstring("error linking SBOMs")))
+						Expect(client.CreateBuildCallCount()).To(Equal(0))
+					})
+				})
+			})
+
+			When("the request should be saved", func() {
+				var requestPath string
+
+				BeforeEach(func() {
+					requestPath = filepath.Join(tempDir(), "requests", "build.json")
+					conf.RequestPath = requestPath
+				})
+
+				It("should write the request that was sent", func() {
+					_, expectedRequest, _ := client.CreateBuildArgsForCall(0)
+
+					actualRequest, err := readRequest(requestPath)
+					Expect(err).NotTo(HaveOccurred())
+					Expect(proto.Equal(actualRequest, expectedRequest)).To(BeTrue())
+				})
+			})
+
+			When("digest resolution is enabled", func() {
+				var expectedDigest string
+
+				BeforeEach(func() {
+					expectedDigest = "sha256:" + fake.LetterN(64)
+					resolver.ResolveDigestReturns(expectedDigest, nil)
+					action.resolver = resolver
+				})
+
+				When("the artifact id is a tag", func() {
+					BeforeEach(func() {
+						conf.ArtifactId = "harbor.example.com/rode/app:v1.2.3"
+						conf.ArtifactNamesDelimiter = "\n"
+						conf.ArtifactNames = "harbor.example.com/rode/app:latest"
+					})
+
+					It("should resolve the tag", func() {
+						Expect(resolver.ResolveDigestCallCount()).To(Equal(1))
+						_, actualReference := resolver.ResolveDigestArgsForCall(0)
+
+						Expect(actualReference).To(Equal(conf.ArtifactId))
+					})
+
+					It("should use the digest as the artifact id and keep the tag as a name", func() {
+						_, actualRequest, _ := client.CreateBuildArgsForCall(0)
+
+						Expect(actualRequest.Artifacts[0].Id).To(Equal("harbor.example.com/rode/app@" + expectedDigest))
+						Expect(actualRequest.Artifacts[0].Names).To(Equal([]string{
+							"harbor.example.com/rode/app:v1.2.3",
+							"harbor.example.com/rode/app:latest",
+						}))
+					})
+				})
+
+				When("the artifact id already has a digest", func() {
+					BeforeEach(func() {
+						conf.ArtifactId = "harbor.example.com/rode/app@sha256:" + fake.LetterN(64)
+					})
+
+					It("should not resolve the digest", func() {
+						Expect(resolver.ResolveDigestCallCount()).To(Equal(0))
+					})
+				})
+
+				When("resolving the digest fails", func() {
+					BeforeEach(func() {
+						conf.ArtifactId = "harbor.example.com/rode/app:v1.2.3"
+						resolver.ResolveDigestReturns("", errors.New(fake.Word()))
+					})
+
+					It("should return an error", func() {
+						Expect(actualError).To(HaveOccurred())
+						Expect(actualError.Error()).To(ContainSubstring("error resolving artifact digest"))
+						Expect(client.CreateBuildCallCount()).To(Equal(0))
+					})
+				})
+			})
+		})
+
+		When("the artifact is invalid", func() {
+			BeforeEach(func() {
+				conf.ArtifactId = ""
+				conf.ArtifactType = "maven"
+				conf.ArtifactName = fake.Word()
+
+				jobs := &github.Jobs{
+					Jobs: []*github.WorkflowJob{
+						{
+							Name: github.String(githubConf.JobId),
+						},
+					},
+				}
+				actionsService.ListWorkflowJobsReturns(jobs, nil, nil)
+			})
+
+			It("should return an error", func() {
+				Expect(actualOccurrenceId).To(BeEmpty())
+				Expect(actualError).To(HaveOccurred())
+				Expect(actualError.Error()).To(ContainSubstring("error building artifact"))
+				Expect(client.CreateBuildCallCount()).To(Equal(0))
+			})
+		})
+
+		When("neither an artifact id nor type is set", func() {
+			BeforeEach(func() {
+				conf.ArtifactId = ""
+
+				jobs := &github.Jobs{
+					Jobs: []*github.WorkflowJob{
+						{
+							Name: github.String(githubConf.JobId),
+						},
+					},
+				}
+				actionsService.ListWorkflowJobsReturns(jobs, nil, nil)
+			})
+
+			It("should return an error", func() {
+				Expect(actualError).To(HaveOccurred())
+				Expect(actualError.Error()).To(ContainSubstring("either artifactId or artifactType is required"))
+			})
+		})
+
+		When("the repository slug is invalid", func() {
+			BeforeEach(func() {
+				githubConf.RepoSlug = fake.Word()
+			})
+
+			It("should return an error instead of listing jobs", func() {
+				Expect(actualError).To(MatchError(ContainSubstring("must be in the form owner/repo")))
+				Expect(actionsService.ListWorkflowJobsCallCount()).To(Equal(0))
+			})
 		})
 
 		When("an error occurs listing jobs", func() {
@@ -195,6 +1168,18 @@ var _ = Describe("createBuildOccurrenceAction", func() {
 				Expect(actualError).To(HaveOccurred())
 				Expect(actualError.Error()).To(ContainSubstring("error listing jobs"))
 			})
+
+			When("metrics are collected", func() {
+				BeforeEach(func() {
+					action.metrics = newBuildMetrics()
+				})
+
+				It("should count the failure against the CI provider", func() {
+					Expect(action.metrics.stage).To(Equal(stageCIProvider))
+					Expect(histogramCount(action.metrics.ciLookup)).To(Equal(uint64(1)))
+					Expect(histogramCount(action.metrics.createBuild)).To(BeZero())
+				})
+			})
 		})
 
 		When("there are no jobs matching the job id", func() {
@@ -214,7 +1199,7 @@ var _ = Describe("createBuildOccurrenceAction", func() {
 				jobs := &github.Jobs{
 					Jobs: []*github.WorkflowJob{
 						{
-							Name: github.String(conf.GitHub.JobId),
+							Name: github.String(githubConf.JobId),
 						},
 					},
 				}
@@ -230,4 +1215,138 @@ var _ = Describe("createBuildOccurrenceAction", func() {
 			})
 		})
 	})
+
+	Describe("timeouts", func() {
+		var (
+			request     *collector.CreateBuildRequest
+			actualError error
+		)
+
+		BeforeEach(func() {
+			request = &collector.CreateBuildRequest{}
+			conf.Timeout = &timeoutConfig{Rpc: 20 * time.Millisecond, Total: time.Minute}
+			client.CreateBuildStub = func(ctx context.Context, _ *collector.CreateBuildRequest, _ ...grpc.CallOption) (*collector.CreateBuildResponse, error) {
+				<-ctx.Done()
+				return nil, status.FromContextError(ctx.Err()).Err()
+			}
+		})
+
+		JustBeforeEach(func() {
+			_, actualError = action.Replay(ctx, request)
+		})
+
+		It("should limit each request to the build collector", func() {
+			Expect(actualError).To(MatchError(HavePrefix("error creating build occurrence: no response within 20ms, set TIMEOUT_RPC to wait longer")))
+		})
+
+		When("the whole action runs out of time", func() {
+			var cancel context.CancelFunc
+
+			BeforeEach(func() {
+				ctx, cancel = context.WithTimeout(ctx, 10*time.Millisecond)
+			})
+
+			AfterEach(func() {
+				cancel()
+			})
+
+			It("should blame the total timeout", func() {
+				Expect(actualError).To(MatchError(HavePrefix("error creating build occurrence: the action didn't finish within 1m0s, set TIMEOUT_TOTAL to allow longer")))
+			})
+		})
+
+		When("the action is cancelled", func() {
+			BeforeEach(func() {
+				var cancel context.CancelFunc
+				ctx, cancel = context.WithCancel(ctx)
+				time.AfterFunc(10*time.Millisecond, cancel)
+			})
+
+			It("should say that it was cancelled", func() {
+				Expect(actualError).To(MatchError(HavePrefix("error creating build occurrence: the action was cancelled")))
+			})
+		})
+	})
+
+	Describe("UpdateArtifacts", func() {
+		var (
+			existingArtifactId   string
+			expectedOccurrenceId string
+			actualOccurrenceId   string
+			actualError          error
+		)
+
+		BeforeEach(func() {
+			existingArtifactId = fake.URL()
+			expectedOccurrenceId = fake.UUID()
+			conf.ArtifactNamesDelimiter = ","
+			conf.ArtifactNames = "v1.2.3,latest"
+
+			client.UpdateBuildArtifactsReturns(&collector.UpdateBuildArtifactsResponse{BuildOccurrenceId: expectedOccurrenceId}, nil)
+		})
+
+		JustBeforeEach(func() {
+			actualOccurrenceId, actualError = action.UpdateArtifacts(ctx, existingArtifactId)
+		})
+
+		It("should add the artifact to the existing build occurrence", func() {
+			Expect(actualError).NotTo(HaveOccurred())
+			Expect(actualOccurrenceId).To(Equal(expectedOccurrenceId))
+			Expect(client.UpdateBuildArtifactsCallCount()).To(Equal(1))
+
+			_, actualRequest, _ := client.UpdateBuildArtifactsArgsForCall(0)
+			Expect(actualRequest.ExistingArtifactId).To(Equal(existingArtifactId))
+			Expect(actualRequest.NewArtifact.Id).To(Equal(conf.ArtifactId))
+			Expect(actualRequest.NewArtifact.Names).To(Equal([]string{"v1.2.3", "latest"}))
+		})
+
+		It("should not create a build occurrence", func() {
+			Expect(client.CreateBuildCallCount()).To(Equal(0))
+			Expect(actionsService.ListWorkflowJobsCallCount()).To(Equal(0))
+		})
+
+		When("the artifact is invalid", func() {
+			BeforeEach(func() {
+				conf.ArtifactType = "npm"
+			})
+
+			It("should return an error", func() {
+				Expect(actualError).To(MatchError(ContainSubstring("error building artifact")))
+				Expect(client.UpdateBuildArtifactsCallCount()).To(Equal(0))
+			})
+		})
+
+		When("the build collector returns an error", func() {
+			BeforeEach(func() {
+				client.UpdateBuildArtifactsReturns(nil, errors.New(fake.Word()))
+			})
+
+			It("should return an error", func() {
+				Expect(actualError).To(MatchError(ContainSubstring("error updating build artifacts")))
+			})
+		})
+	})
+
+	Describe("Replay", func() {
+		var request *collector.CreateBuildRequest
+
+		BeforeEach(func() {
+			request = &collector.CreateBuildRequest{
+				Artifacts:  []*collector.Artifact{{Id: conf.ArtifactId}},
+				CommitId:   fake.LetterN(10),
+				Repository: fake.URL(),
+			}
+			client.CreateBuildReturns(&collector.CreateBuildResponse{BuildOccurrenceId: "abc"}, nil)
+		})
+
+		It("should send the request as is", func() {
+			actualOccurrenceId, err := action.Replay(ctx, request)
+
+			Expect(err).NotTo(HaveOccurred())
+			Expect(actualOccurrenceId).To(Equal("abc"))
+			_, actualRequest, _ := client.CreateBuildArgsForCall(0)
+			Expect(actualRequest).To(BeIdenticalTo(request))
+			Expect(actionsService.ListWorkflowJobsCallCount()).To(Equal(0))
+		})
+	})
 })
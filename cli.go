@@ -0,0 +1,246 @@
+// Copyright 2021 The Rode Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"reflect"
+	"strings"
+
+	"github.com/sethvargo/go-envconfig"
+)
+
+// cliDefaults are used when a value isn't set anywhere else, so that the command line matches the defaults of the action inputs
+var cliDefaults = map[string]string{
+	"ARTIFACT_NAMES_DELIMITER": "\n",
+}
+
+// configField describes a field of the config by its environment variable
+type configField struct {
+	Key      string
+	Usage    string
+	Default  string
+	Required bool
+	Bool     bool
+}
+
+func (f configField) flagName() string {
+	return strings.ToLower(strings.ReplaceAll(f.Key, "_", "-"))
+}
+
+func (f configField) flagUsage() string {
+	details := []string{f.Key}
+	if f.Required {
+		details = append(details, "required")
+	}
+
+	if f.Default != "" {
+		details = append(details, fmt.Sprintf("default %q", f.Default))
+	} else if value, ok := cliDefaults[f.Key]; ok {
+		details = append(details, fmt.Sprintf("default %q", value))
+	}
+
+	return fmt.Sprintf("%s (%s)", f.Usage, strings.Join(details, ", "))
+}
+
+// configFields lists the environment variables for the fields of the struct, including the prefixes of nested structs
+func configFields(t reflect.Type, prefix string) []configField {
+	var fields []configField
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		tag, ok := field.Tag.Lookup("env")
+		if !ok {
+			continue
+		}
+
+		options := strings.Split(tag, ",")
+		configField := configField{
+			Key:   prefix + options[0],
+			Usage: field.Tag.Get("usage"),
+			Bool:  field.Type.Kind() == reflect.Bool,
+		}
+
+		nestedPrefix := ""
+		for _, option := range options[1:] {
+			switch {
+			case option == "required":
+				configField.Required = true
+			case strings.HasPrefix(option, "default="):
+				configField.Default = strings.TrimPrefix(option, "default=")
+			case strings.HasPrefix(option, "prefix="):
+				nestedPrefix = strings.TrimPrefix(option, "prefix=")
+			}
+		}
+
+		if field.Type.Kind() == reflect.Ptr && field.Type.Elem().Kind() == reflect.Struct {
+			fields = append(fields, configFields(field.Type.Elem(), prefix+nestedPrefix)...)
+			continue
+		}
+
+		fields = append(fields, configField)
+	}
+
+	return fields
+}
+
+// configFlag stores the flag under the environment variable that it overrides
+type configFlag struct {
+	key    string
+	bool   bool
+	values map[string]string
+}
+
+func (f *configFlag) String() string {
+	return f.values[f.key]
+}
+
+func (f *configFlag) Set(value string) error {
+	f.values[f.key] = value
+
+	return nil
+}
+
+func (f *configFlag) IsBoolFlag() bool {
+	return f.bool
+}
+
+// configSource resolves config values from, in order of precedence, flags, the environment, an env file, the config file and then defaults
+type configSource struct {
+	configFile string
+	envFile    string
+	flags      map[string]string
+}
+
+// addConfigFlags adds a flag for every field of the config
+func addConfigFlags(flags *flag.FlagSet) *configSource {
+	source := &configSource{
+		flags: map[string]string{},
+	}
+
+	for _, field := range configFields(reflect.TypeOf(config{}), "") {
+		flags.Var(&configFlag{key: field.Key, bool: field.Bool, values: source.flags}, field.flagName(), field.flagUsage())
+	}
+	flags.StringVar(&source.envFile, "env-file", "", "A file of KEY=VALUE lines to use for any variables that aren't set in the environment")
+	flags.StringVar(&source.configFile, "config-file", "", fmt.Sprintf("A YAML file of shared defaults (CONFIG_FILE, default %q)", defaultConfigFile))
+
+	return source
+}
+
+func (s *configSource) lookuper() (envconfig.Lookuper, error) {
+	lookupers := []envconfig.Lookuper{
+		envconfig.MapLookuper(s.flags),
+		envconfig.OsLookuper(),
+	}
+
+	if s.configFile != "" {
+		s.flags["CONFIG_FILE"] = s.configFile
+	}
+
+	if s.envFile != "" {
+		env, err := readEnvFile(s.envFile)
+		if err != nil {
+			return nil, err
+		}
+		lookupers = append(lookupers, envconfig.MapLookuper(env))
+	}
+
+	// the config file can be chosen by any of the sources above, and only has to exist when it's not the default
+	configFile, ok := layeredLookuper(lookupers).Lookup("CONFIG_FILE")
+	if !ok {
+		configFile = defaultConfigFile
+	}
+
+	fileValues, err := readConfigFile(configFile, configFile != defaultConfigFile)
+	if err != nil {
+		return nil, err
+	}
+
+	return layeredLookuper(append(lookupers, envconfig.MapLookuper(fileValues), envconfig.MapLookuper(cliDefaults))), nil
+}
+
+// layeredLookuper returns the first value that isn't empty. Unlike envconfig.MultiLookuper, an empty value doesn't hide
+// the sources below it, since the action sets an empty variable for every input that's omitted.
+type layeredLookuper []envconfig.Lookuper
+
+func (l layeredLookuper) Lookup(key string) (string, bool) {
+	for _, lookuper := range l {
+		if value, ok := lookuper.Lookup(key); ok && value != "" {
+			return value, true
+		}
+	}
+
+	return "", false
+}
+
+// load builds the config, and returns the lookuper so that CI providers see the same values
+func (s *configSource) load(ctx context.Context) (*config, envconfig.Lookuper, error) {
+	l, err := s.lookuper()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	c := &config{}
+	if err := envconfig.ProcessWith(ctx, c, l); err != nil {
+		return nil, nil, fmt.Errorf("unable to build config: %s", err)
+	}
+
+	return c, l, nil
+}
+
+// readEnvFile parses a dotenv style file, e.g. the .env file described in the README
+func readEnvFile(path string) (map[string]string, error) {
+	contents, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read env file: %s", err)
+	}
+
+	env := map[string]string{}
+	scanner := bufio.NewScanner(bytes.NewReader(contents))
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		parts := strings.SplitN(strings.TrimPrefix(line, "export "), "=", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("%s:%d: expected KEY=VALUE", path, lineNumber)
+		}
+
+		value := strings.TrimSpace(parts[1])
+		if len(value) >= 2 && (value[0] == '"' || value[0] == '\'') && value[len(value)-1] == value[0] {
+			value = value[1 : len(value)-1]
+		}
+
+		env[strings.TrimSpace(parts[0])] = value
+	}
+
+	return env, scanner.Err()
+}
+
+func newFlagSet(name, arguments, description string) *flag.FlagSet {
+	flags := flag.NewFlagSet(name, flag.ContinueOnError)
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: action %s %s\n\n%s\n\nFlags:\n", name, arguments, description)
+		flags.PrintDefaults()
+	}
+
+	return flags
+}
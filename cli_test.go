@@ -0,0 +1,182 @@
+// Copyright 2021 The Rode Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"flag"
+	"os"
+	"path/filepath"
+	"reflect"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("cli", func() {
+	Describe("configFields", func() {
+		var fields []configField
+
+		BeforeEach(func() {
+			fields = configFields(reflect.TypeOf(config{}), "")
+		})
+
+		It("should include the prefix of nested config", func() {
+			Expect(fields).To(ContainElement(configField{
+				Key:   "BUILD_COLLECTOR_HOST",
+				Usage: "The build collector host and port. Required unless BUILD_COLLECTORS is set",
+			}))
+			Expect(fields).To(ContainElement(configField{
+				Key:     "PROVENANCE_VERSION",
+				Usage:   "The SLSA provenance version to generate, either v0.2 or v1",
+				Default: "v0.2",
+			}))
+		})
+
+		It("should describe every field", func() {
+			for _, field := range fields {
+				Expect(field.Usage).NotTo(BeEmpty(), field.Key)
+			}
+		})
+	})
+
+	Describe("configSource", func() {
+		var (
+			ctx     context.Context
+			flags   *flag.FlagSet
+			source  *configSource
+			args    []string
+			envFile string
+
+			actualConfig *config
+			actualError  error
+		)
+
+		BeforeEach(func() {
+			ctx = context.Background()
+			flags = newFlagSet("test", "", "")
+			flags.SetOutput(&bytes.Buffer{})
+			source = addConfigFlags(flags)
+			envFile = filepath.Join(tempDir(), ".env")
+			Expect(os.WriteFile(envFile, []byte(`# local settings
+BUILD_COLLECTOR_HOST=collector.example.com:443
+export ARTIFACT_ID="harbor.example.com/rode/app@sha256:123"
+ARTIFACT_NAMES='latest'
+`), 0644)).To(Succeed())
+
+			args = []string{"-build-collector-host", "localhost:8082", "-build-collector-insecure", "-env-file", envFile}
+			os.Setenv("ARTIFACT_NAMES", "v1.2.3")
+			os.Setenv("BUILD_COLLECTOR_HOST", "rode.example.com:443")
+		})
+
+		AfterEach(func() {
+			os.Unsetenv("ARTIFACT_NAMES")
+			os.Unsetenv("BUILD_COLLECTOR_HOST")
+		})
+
+		JustBeforeEach(func() {
+			Expect(flags.Parse(args)).To(Succeed())
+			actualConfig, _, actualError = source.load(ctx)
+		})
+
+		It("should prefer flags, then the environment, then the env file, then defaults", func() {
+			Expect(actualError).NotTo(HaveOccurred())
+			Expect(actualConfig.BuildCollector.Host).To(Equal("localhost:8082"))
+			Expect(actualConfig.ArtifactNames).To(Equal("v1.2.3"))
+			Expect(actualConfig.ArtifactId).To(Equal("harbor.example.com/rode/app@sha256:123"))
+			Expect(actualConfig.ArtifactNamesDelimiter).To(Equal("\n"))
+			Expect(actualConfig.Provenance.Version).To(Equal("v0.2"))
+		})
+
+		When("there is a config file", func() {
+			BeforeEach(func() {
+				configFile := filepath.Join(tempDir(), "build-occurrence.yaml")
+				Expect(os.WriteFile(configFile, []byte(`artifactId: ignored
+provenance:
+  version: v1
+signing:
+  envelopePath: signed/build.dsse.json
+`), 0644)).To(Succeed())
+
+				args = append(args, "-config-file", configFile)
+				os.Setenv("SIGNING_ENVELOPE_PATH", "")
+			})
+
+			AfterEach(func() {
+				os.Unsetenv("SIGNING_ENVELOPE_PATH")
+			})
+
+			It("should use the file for values that aren't set elsewhere", func() {
+				Expect(actualError).NotTo(HaveOccurred())
+				Expect(actualConfig.ArtifactId).To(Equal("harbor.example.com/rode/app@sha256:123"))
+				Expect(actualConfig.Provenance.Version).To(Equal("v1"))
+				Expect(actualConfig.Signing.EnvelopePath).To(Equal("signed/build.dsse.json"))
+			})
+		})
+
+		When("the config file doesn't exist", func() {
+			BeforeEach(func() {
+				args = append(args, "-config-file", filepath.Join(tempDir(), "missing.yaml"))
+			})
+
+			It("should return an error", func() {
+				Expect(actualError).To(MatchError(ContainSubstring("unable to read config file")))
+			})
+		})
+
+		It("should allow boolean flags without a value", func() {
+			Expect(actualConfig.BuildCollector.Insecure).To(BeTrue())
+		})
+
+		When("the env file is malformed", func() {
+			BeforeEach(func() {
+				Expect(os.WriteFile(envFile, []byte("BUILD_COLLECTOR_HOST\n"), 0644)).To(Succeed())
+			})
+
+			It("should return an error with the line number", func() {
+				Expect(actualError).To(MatchError(ContainSubstring(".env:1: expected KEY=VALUE")))
+			})
+		})
+
+		When("the build collector is missing", func() {
+			BeforeEach(func() {
+				args = nil
+				os.Unsetenv("BUILD_COLLECTOR_HOST")
+			})
+
+			It("should be reported by the validation, as BUILD_COLLECTORS can be used instead", func() {
+				Expect(actualError).NotTo(HaveOccurred())
+				Expect(validateConfig(actualConfig, nil, false)).To(MatchError(ContainSubstring("BUILD_COLLECTOR_HOST: must be set, or list the build collectors in BUILD_COLLECTORS")))
+			})
+		})
+	})
+
+	Describe("usage", func() {
+		It("should list every config field as a flag", func() {
+			output := &bytes.Buffer{}
+			flags := newFlagSet("create", "[flags]", "Records a build.")
+			flags.SetOutput(output)
+			addConfigFlags(flags)
+
+			Expect(flags.Parse([]string{"-h"})).To(MatchError(flag.ErrHelp))
+			Expect(output.String()).To(ContainSubstring("Usage: action create [flags]"))
+			for _, field := range configFields(reflect.TypeOf(config{}), "") {
+				Expect(output.String()).To(MatchRegexp(`\n  -%s[ \n]`, field.flagName()), field.Key)
+			}
+			Expect(output.String()).To(ContainSubstring(`(SIGNING_ENVELOPE_PATH, default "build.dsse.json")`))
+		})
+	})
+})
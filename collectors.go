@@ -0,0 +1,265 @@
+// Copyright 2021 The Rode Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+
+	collector "github.com/rode/collector-build/proto/v1alpha1"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	// defaultCollectorName is the name of the build collector configured by BUILD_COLLECTOR_HOST, used in the ids output
+	defaultCollectorName = "default"
+
+	partialFailureFail = "fail"
+	partialFailureWarn = "warn"
+)
+
+// collectorTarget is a build collector that the build is recorded in, either one listed in BUILD_COLLECTORS or the one configured
+// by BUILD_COLLECTOR_HOST
+type collectorTarget struct {
+	Name        string `yaml:"name"`
+	Host        string `yaml:"host"`
+	Insecure    bool   `yaml:"insecure"`
+	CaFile      string `yaml:"caFile"`
+	AccessToken string `yaml:"accessToken"`
+
+	// index is the position in BUILD_COLLECTORS, or -1 for the default build collector
+	index int
+}
+
+func defaultCollectorTarget(c *config) *collectorTarget {
+	return &collectorTarget{
+		Name:        defaultCollectorName,
+		Host:        c.BuildCollector.Host,
+		Insecure:    c.BuildCollector.Insecure,
+		CaFile:      c.BuildCollector.CaFile,
+		AccessToken: c.AccessToken,
+		index:       -1,
+	}
+}
+
+// collectorTargets parses BUILD_COLLECTORS, a YAML or JSON list of build collectors that each have a name, host and,
+// optionally, insecure, caFile and accessToken. When it isn't set, the build is only recorded in the build collector
+// configured by BUILD_COLLECTOR_HOST.
+func collectorTargets(c *config) ([]*collectorTarget, error) {
+	if c.BuildCollectors == "" {
+		return []*collectorTarget{defaultCollectorTarget(c)}, nil
+	}
+
+	var targets []*collectorTarget
+	decoder := yaml.NewDecoder(strings.NewReader(c.BuildCollectors))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(&targets); err != nil {
+		return nil, fmt.Errorf("BUILD_COLLECTORS: %s", err)
+	}
+
+	for i, target := range targets {
+		if target == nil {
+			return nil, fmt.Errorf("BUILD_COLLECTORS: item %d is empty", i)
+		}
+		target.index = i
+	}
+
+	return targets, nil
+}
+
+// setting names where one of the target's settings comes from, e.g. BUILD_COLLECTOR_HOST or BUILD_COLLECTORS[1].host
+func (t *collectorTarget) setting(key string) string {
+	if t.index != -1 {
+		return fmt.Sprintf("BUILD_COLLECTORS[%d].%s", t.index, key)
+	}
+
+	switch key {
+	case "host":
+		return "BUILD_COLLECTOR_HOST"
+	case "insecure":
+		return "BUILD_COLLECTOR_INSECURE"
+	case "caFile":
+		return "BUILD_COLLECTOR_CA_FILE"
+	}
+
+	return "ACCESS_TOKEN"
+}
+
+// plaintextToken is whether the access token is sent without TLS to another machine. That's allowed, e.g. for a build collector in
+// the same cluster, but anyone on the network in between can read the token, so it's warned about.
+func (t *collectorTarget) plaintextToken() bool {
+	return t.Insecure && t.AccessToken != "" && !isLoopback(t.Host)
+}
+
+// tlsConfig trusts the CA file as well as the system's roots, when one is configured
+func (t *collectorTarget) tlsConfig() (*tls.Config, error) {
+	if t.CaFile == "" {
+		return &tls.Config{}, nil
+	}
+
+	contents, err := os.ReadFile(t.CaFile)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read %s: %s", t.setting("caFile"), err)
+	}
+
+	roots, err := x509.SystemCertPool()
+	if err != nil {
+		roots = x509.NewCertPool()
+	}
+
+	if !roots.AppendCertsFromPEM(contents) {
+		return nil, fmt.Errorf("%s: no PEM certificates found in %s", t.setting("caFile"), t.CaFile)
+	}
+
+	return &tls.Config{RootCAs: roots}, nil
+}
+
+func validateCollectors(c *config) configErrors {
+	targets, err := collectorTargets(c)
+	if err != nil {
+		return configErrors{err.Error()}
+	}
+
+	var problems configErrors
+	if c.BuildCollectors != "" {
+		if c.BuildCollector.Host != "" {
+			problems = append(problems, "BUILD_COLLECTOR_HOST: can't be used with BUILD_COLLECTORS, add it to the list instead")
+		}
+
+		if c.AccessToken != "" {
+			problems = append(problems, "ACCESS_TOKEN: can't be used with BUILD_COLLECTORS, set the accessToken of each build collector instead")
+		}
+
+		if len(targets) == 0 {
+			problems = append(problems, "BUILD_COLLECTORS: must list at least one build collector")
+		}
+	} else if c.BuildCollector.Host == "" {
+		return append(problems, "BUILD_COLLECTOR_HOST: must be set, or list the build collectors in BUILD_COLLECTORS")
+	}
+
+	names := map[string]bool{}
+	for _, target := range targets {
+		if target.Name == "" {
+			problems = append(problems, fmt.Sprintf("%s: must be set", target.setting("name")))
+		} else if names[target.Name] {
+			problems = append(problems, fmt.Sprintf("%s: %q is used by another build collector", target.setting("name"), target.Name))
+		}
+		names[target.Name] = true
+
+		problems = append(problems, validateHostPort(target.setting("host"), target.Host)...)
+		if target.Insecure && target.CaFile != "" {
+			problems = append(problems, fmt.Sprintf("%s: is only used with TLS, unset %s to use it", target.setting("caFile"), target.setting("insecure")))
+		}
+	}
+
+	switch c.PartialFailure {
+	case "", partialFailureFail, partialFailureWarn:
+	default:
+		problems = append(problems, fmt.Sprintf("PARTIAL_FAILURE: %q is not supported, expected %s or %s", c.PartialFailure, partialFailureFail, partialFailureWarn))
+	}
+
+	return problems
+}
+
+// buildCollector is a connected build collector
+type buildCollector struct {
+	name   string
+	client collector.BuildCollectorClient
+}
+
+// describe names the build collector in log messages, when there's more than one
+func (a *createBuildOccurrenceAction) describe(c *buildCollector) string {
+	if len(a.collectors) == 1 {
+		return ""
+	}
+
+	return " in " + c.name
+}
+
+type collectorResult struct {
+	name string
+	id   string
+	err  error
+}
+
+// fanOut makes the same call to every build collector at once, each limited by the RPC timeout
+func (a *createBuildOccurrenceAction) fanOut(ctx context.Context, call func(ctx context.Context, c *buildCollector) (string, error)) []collectorResult {
+	results := make([]collectorResult, len(a.collectors))
+	var wg sync.WaitGroup
+	for i, c := range a.collectors {
+		wg.Add(1)
+		go func(i int, c *buildCollector) {
+			defer wg.Done()
+			rpcCtx, cancel := withTimeout(ctx, a.config.Timeout.Rpc)
+			defer cancel()
+
+			id, err := call(rpcCtx, c)
+			results[i] = collectorResult{name: c.name, id: id, err: err}
+		}(i, c)
+	}
+	wg.Wait()
+
+	return results
+}
+
+// collectResults sets the ids output, a JSON object of the occurrence id from each build collector that succeeded, and returns
+// the id from the first of them. When some of the build collectors failed, the partial failure policy decides whether that's an
+// error. ctx is the context of the whole action, action describes the call for errors.
+func (a *createBuildOccurrenceAction) collectResults(ctx context.Context, results []collectorResult, action string) (string, error) {
+	ids := map[string]string{}
+	var id string
+	var failures []string
+	for _, result := range results {
+		if result.err != nil {
+			err := describeTimeout(ctx, result.err, a.config.Timeout)
+			if len(results) == 1 {
+				failures = append(failures, err.Error())
+			} else {
+				failures = append(failures, fmt.Sprintf("%s: %s", result.name, err))
+			}
+			continue
+		}
+
+		ids[result.name] = result.id
+		if id == "" {
+			id = result.id
+		}
+	}
+
+	contents, err := json.Marshal(ids)
+	if err != nil {
+		return "", err
+	}
+	a.setOutput("ids", string(contents))
+
+	if len(failures) == 0 {
+		return id, nil
+	}
+
+	err = fmt.Errorf("error %s: %s", action, strings.Join(failures, "; "))
+	if len(ids) == 0 || a.config.PartialFailure != partialFailureWarn {
+		return "", err
+	}
+
+	a.logger.Warn(err.Error())
+
+	return id, nil
+}
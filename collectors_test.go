@@ -0,0 +1,248 @@
+// Copyright 2021 The Rode Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"os"
+	"path/filepath"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	collector "github.com/rode/collector-build/proto/v1alpha1"
+	"github.com/rode/create-build-occurrence-action/mocks"
+	"go.uber.org/zap"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+var _ = Describe("build collectors", func() {
+	var conf *config
+
+	BeforeEach(func() {
+		conf = &config{
+			AccessToken:    "token",
+			BuildCollector: &buildCollectorConfig{Host: "rode.example.com:443", CaFile: "/etc/ssl/rode.pem"},
+		}
+	})
+
+	Describe("collectorTargets", func() {
+		It("should use BUILD_COLLECTOR_HOST when there's no list", func() {
+			targets, err := collectorTargets(conf)
+
+			Expect(err).NotTo(HaveOccurred())
+			Expect(targets).To(HaveLen(1))
+			Expect(*targets[0]).To(Equal(collectorTarget{Name: "default", Host: "rode.example.com:443", CaFile: "/etc/ssl/rode.pem", AccessToken: "token", index: -1}))
+		})
+
+		It("should parse the list", func() {
+			conf.BuildCollectors = `
+- name: staging
+  host: localhost:8082
+  insecure: true
+- name: prod
+  host: rode.example.com:443
+  accessToken: secret
+`
+			targets, err := collectorTargets(conf)
+
+			Expect(err).NotTo(HaveOccurred())
+			Expect(targets).To(HaveLen(2))
+			Expect(*targets[0]).To(Equal(collectorTarget{Name: "staging", Host: "localhost:8082", Insecure: true}))
+			Expect(*targets[1]).To(Equal(collectorTarget{Name: "prod", Host: "rode.example.com:443", AccessToken: "secret", index: 1}))
+			Expect(targets[1].setting("host")).To(Equal("BUILD_COLLECTORS[1].host"))
+		})
+
+		It("should accept JSON", func() {
+			conf.BuildCollectors = `[{"name": "prod", "host": "rode.example.com:443"}]`
+
+			targets, err := collectorTargets(conf)
+
+			Expect(err).NotTo(HaveOccurred())
+			Expect(targets[0].Host).To(Equal("rode.example.com:443"))
+		})
+
+		It("should reject unknown settings", func() {
+			conf.BuildCollectors = `[{"name": "prod", "hostname": "rode.example.com:443"}]`
+
+			_, err := collectorTargets(conf)
+
+			Expect(err).To(MatchError(ContainSubstring(`BUILD_COLLECTORS: yaml: unmarshal errors:`)))
+			Expect(err).To(MatchError(ContainSubstring(`field hostname not found`)))
+		})
+	})
+
+	Describe("tlsConfig", func() {
+		It("should trust the CA file", func() {
+			cert := selfSignedCertificate()
+			caFile := filepath.Join(tempDir(), "ca.pem")
+			Expect(os.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Certificate[0]}), 0644)).To(Succeed())
+
+			tlsConfig, err := (&collectorTarget{CaFile: caFile, index: -1}).tlsConfig()
+
+			Expect(err).NotTo(HaveOccurred())
+			Expect(tlsConfig.RootCAs).NotTo(BeNil())
+		})
+
+		It("should report a file without certificates", func() {
+			caFile := filepath.Join(tempDir(), "ca.pem")
+			Expect(os.WriteFile(caFile, []byte("not a certificate"), 0644)).To(Succeed())
+
+			_, err := (&collectorTarget{CaFile: caFile, index: 0}).tlsConfig()
+
+			Expect(err).To(MatchError("BUILD_COLLECTORS[0].caFile: no PEM certificates found in " + caFile))
+		})
+	})
+
+	Describe("sending to each build collector", func() {
+		var (
+			ctx         context.Context
+			action      *createBuildOccurrenceAction
+			staging     *mocks.FakeBuildCollectorClient
+			prod        *mocks.FakeBuildCollectorClient
+			actualId    string
+			actualError error
+		)
+
+		BeforeEach(func() {
+			ctx = context.Background()
+			staging = &mocks.FakeBuildCollectorClient{}
+			prod = &mocks.FakeBuildCollectorClient{}
+			staging.CreateBuildReturns(&collector.CreateBuildResponse{BuildOccurrenceId: "staging-1"}, nil)
+			prod.CreateBuildReturns(&collector.CreateBuildResponse{BuildOccurrenceId: "prod-1"}, nil)
+			action = &createBuildOccurrenceAction{
+				config: &config{Timeout: &timeoutConfig{}},
+				collectors: []*buildCollector{
+					{name: "staging", client: staging},
+					{name: "prod", client: prod},
+				},
+				logger: zap.NewNop(),
+			}
+		})
+
+		JustBeforeEach(func() {
+			actualId, actualError = action.Replay(ctx, &collector.CreateBuildRequest{CommitId: "foobar"})
+		})
+
+		It("should send the same request to each build collector", func() {
+			Expect(actualError).NotTo(HaveOccurred())
+			_, stagingRequest, _ := staging.CreateBuildArgsForCall(0)
+			_, prodRequest, _ := prod.CreateBuildArgsForCall(0)
+			Expect(stagingRequest).To(BeIdenticalTo(prodRequest))
+		})
+
+		It("should return the id from the first build collector, and every id as an output", func() {
+			Expect(actualId).To(Equal("staging-1"))
+
+			var ids map[string]string
+			Expect(json.Unmarshal([]byte(action.outputs["ids"]), &ids)).To(Succeed())
+			Expect(ids).To(Equal(map[string]string{"staging": "staging-1", "prod": "prod-1"}))
+		})
+
+		When("one of the build collectors fails", func() {
+			BeforeEach(func() {
+				staging.CreateBuildReturns(nil, errors.New("connection reset"))
+			})
+
+			It("should fail the step by default, naming the build collector", func() {
+				Expect(actualError).To(MatchError("error creating build occurrence: staging: connection reset"))
+				Expect(actualId).To(BeEmpty())
+				Expect(action.outputs["ids"]).To(MatchJSON(`{"prod": "prod-1"}`))
+			})
+
+			When("partial failures are allowed", func() {
+				BeforeEach(func() {
+					action.config.PartialFailure = partialFailureWarn
+				})
+
+				It("should return the id from the build collector that succeeded", func() {
+					Expect(actualError).NotTo(HaveOccurred())
+					Expect(actualId).To(Equal("prod-1"))
+				})
+			})
+		})
+
+		When("every build collector fails", func() {
+			BeforeEach(func() {
+				action.config.PartialFailure = partialFailureWarn
+				staging.CreateBuildReturns(nil, errors.New("connection reset"))
+				prod.CreateBuildReturns(nil, status.Error(codes.Unauthenticated, "invalid token"))
+			})
+
+			It("should fail even when partial failures are allowed", func() {
+				Expect(actualError).To(MatchError(ContainSubstring("staging: connection reset; prod: rpc error: code = Unauthenticated desc = invalid token")))
+				Expect(action.outputs["ids"]).To(Equal("{}"))
+			})
+		})
+	})
+
+	Describe("newAction", func() {
+		var staging, prod *fakeCollector
+
+		BeforeEach(func() {
+			staging = &fakeCollector{id: "staging-1", token: "staging-token"}
+			staging.start(false)
+			prod = &fakeCollector{id: "prod-1", token: "prod-token"}
+			prod.start(true)
+
+			targets, err := json.Marshal([]map[string]interface{}{
+				{"name": "staging", "host": staging.host, "insecure": true, "accessToken": "staging-token"},
+				{"name": "prod", "host": prod.host, "caFile": prod.caFile, "accessToken": "prod-token"},
+			})
+			Expect(err).NotTo(HaveOccurred())
+			conf = &config{
+				BuildCollector:  &buildCollectorConfig{},
+				BuildCollectors: string(targets),
+				Policy:          &policyConfig{},
+				Signing:         &signingConfig{},
+				Timeout:         &timeoutConfig{Dial: time.Second, Rpc: time.Second},
+				Tracing:         &tracingConfig{},
+			}
+		})
+
+		AfterEach(func() {
+			staging.stop()
+			prod.stop()
+		})
+
+		It("should connect to each build collector with its own TLS and access token", func() {
+			action, closeConns, err := newAction(context.Background(), conf)
+			Expect(err).NotTo(HaveOccurred())
+			defer closeConns()
+
+			id, err := action.Replay(context.Background(), &collector.CreateBuildRequest{CommitId: "foobar"})
+
+			Expect(err).NotTo(HaveOccurred())
+			Expect(id).To(Equal("staging-1"))
+			Expect(action.outputs["ids"]).To(MatchJSON(`{"staging": "staging-1", "prod": "prod-1"}`))
+			Expect(staging.receivedCreates()).To(HaveLen(1))
+			Expect(prod.receivedCreates()).To(HaveLen(1))
+			Expect(staging.receivedCreates()[0].CommitId).To(Equal("foobar"))
+			Expect(prod.receivedCreates()[0].CommitId).To(Equal("foobar"))
+		})
+
+		It("should name the build collector it couldn't connect to", func() {
+			prod.stop()
+
+			_, _, err := newAction(context.Background(), conf)
+
+			Expect(err).To(MatchError(HavePrefix("unable to connect to prod build collector")))
+		})
+	})
+})
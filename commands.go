@@ -0,0 +1,502 @@
+// Copyright 2021 The Rode Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	collector "github.com/rode/collector-build/proto/v1alpha1"
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/encoding/protojson"
+)
+
+type command struct {
+	name    string
+	summary string
+	run     func(ctx context.Context, args []string) error
+}
+
+func newCommands() []*command {
+	return []*command{
+		{name: "run", summary: "Record the build of the current CI job (default)", run: runCommand},
+		{name: "create", summary: "Record a build described entirely by flags, without a CI system", run: createCommand},
+		{name: "update-artifacts", summary: "Add an artifact to an existing build occurrence", run: updateArtifactsCommand},
+		{name: "replay", summary: "Resend a request that was saved with -request-path", run: replayCommand},
+		{name: "diagnose", summary: "Check each step of connecting to the build collector", run: diagnoseCommand},
+		{name: "validate-config", summary: "Check the configuration without contacting the build collector", run: validateConfigCommand},
+		{name: "verify", summary: "Verify the signature of a DSSE envelope", run: verifyCommand},
+		{name: "version", summary: "Print the version", run: versionCommand},
+	}
+}
+
+func printUsage(w io.Writer, commands []*command) {
+	fmt.Fprintf(w, "Usage: action [command] [flags]\n\nCommands:\n")
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	for _, c := range commands {
+		fmt.Fprintf(tw, "  %s\t%s\n", c.name, c.summary)
+	}
+	tw.Flush()
+	fmt.Fprintf(w, "\nRun `action <command> -h` to list the flags for a command. Flags take precedence over environment variables,\n"+
+		"then the -env-file, then the config file (-config-file or CONFIG_FILE, default %s), then the built-in defaults.\n", defaultConfigFile)
+}
+
+// newAction connects to the build collector, and loads the signing key and registry credentials when they're configured
+func newAction(ctx context.Context, c *config) (*createBuildOccurrenceAction, func(), error) {
+	logger, err := newLogger()
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to create logger: %s", err)
+	}
+
+	action := &createBuildOccurrenceAction{
+		annotations: os.Stdout,
+		config:      c,
+		logger:      logger,
+		metrics:     metricsFrom(ctx),
+	}
+
+	if c.Signing.Key != "" {
+		signer, err := loadSigningKey([]byte(c.Signing.Key), c.Signing.KeyPassphrase)
+		if err != nil {
+			return nil, nil, fmt.Errorf("unable to load signing key: %s", err)
+		}
+		action.signer = signer
+	}
+
+	if c.ResolveDigest {
+		resolver, err := newRegistryClient(newHTTPClient(c.Timeout), c.Registry)
+		if err != nil {
+			return nil, nil, fmt.Errorf("unable to create registry client: %s", err)
+		}
+		action.resolver = resolver
+	}
+
+	action.metrics.enter(stageBuildCollector)
+	targets, err := collectorTargets(c)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if c.Preflight {
+		for _, target := range targets {
+			logger.Info(fmt.Sprintf("Checking the connection to the %s", describeTarget(target, len(targets))))
+			report := diagnoseCollector(ctx, c, target)
+			if report.failed() {
+				return nil, nil, fmt.Errorf("unable to connect to %s:\n%s", describeTarget(target, len(targets)), report)
+			}
+		}
+	}
+
+	var conns []*grpc.ClientConn
+	closeConns := func() {
+		for _, conn := range conns {
+			conn.Close()
+		}
+		logger.Sync()
+	}
+
+	for _, target := range targets {
+		if target.plaintextToken() {
+			logger.Warn(fmt.Sprintf("The access token is sent to the %s without TLS", describeTarget(target, len(targets))))
+		}
+
+		conn, client, err := newBuildCollectorClient(ctx, c, target)
+		if err != nil {
+			closeConns()
+			return nil, nil, connectionError(ctx, c, target, len(targets), err)
+		}
+		conns = append(conns, conn)
+		action.collectors = append(action.collectors, &buildCollector{name: target.Name, client: client})
+	}
+
+	if c.Policy.Ids != "" {
+		if rodeTarget(c).plaintextToken() {
+			logger.Warn("The access token is sent to the Rode API without TLS")
+		}
+
+		conn, client, err := newRodeClient(ctx, c)
+		if err != nil {
+			closeConns()
+			return nil, nil, fmt.Errorf("unable to connect to the Rode API: %s", describeTimeout(ctx, err, c.Timeout))
+		}
+		conns = append(conns, conn)
+		action.policy = client
+	}
+
+	return action, closeConns, nil
+}
+
+// describeTarget only names the build collector when there's more than one
+func describeTarget(target *collectorTarget, targets int) string {
+	if targets == 1 {
+		return "build collector"
+	}
+
+	return target.Name + " build collector"
+}
+
+// connectionError describes a failure to connect to a build collector, with the diagnostics for it
+func connectionError(ctx context.Context, c *config, target *collectorTarget, targets int, err error) error {
+	err = fmt.Errorf("unable to connect to %s: %s", describeTarget(target, targets), err)
+
+	// there's no point diagnosing the connection when the action has been cancelled or run out of time
+	if ctx.Err() != nil {
+		return describeTimeout(ctx, err, c.Timeout)
+	}
+
+	return fmt.Errorf("%s\n%s", err, diagnoseCollector(ctx, c, target))
+}
+
+// setOutputs sets the outputs other than the id, including those set before a failure or cancellation, as they're still useful
+// to later steps
+func setOutputs(action *createBuildOccurrenceAction) {
+	for _, name := range action.outputs.names() {
+		setOutputVariable(name, action.outputs[name])
+	}
+}
+
+// createBuild sends the build occurrence to the collector and sets the outputs
+func createBuild(ctx context.Context, c *config, provider ciProvider) (err error) {
+	ctx, cancel := withTimeout(ctx, c.Timeout.Total)
+	defer cancel()
+	defer func() { metricsFrom(ctx).record(ctx, err) }()
+
+	action, closeConn, err := newAction(ctx, c)
+	if err != nil {
+		return err
+	}
+	defer closeConn()
+	action.provider = provider
+
+	defer setOutputs(action)
+
+	// the id is set even when there's an error, if the build was recorded but didn't pass policy
+	occurrenceId, err := action.Run(ctx)
+	if occurrenceId != "" {
+		setOutputVariable("id", occurrenceId)
+	}
+
+	return err
+}
+
+func runCommand(ctx context.Context, args []string) (err error) {
+	started := time.Now()
+	flags := newFlagSet("run", "[flags]", "Records the build of the current CI job, using the details from the CI system's environment.")
+	source := addConfigFlags(flags)
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	c, l, err := source.load(ctx)
+	if err != nil {
+		return err
+	}
+
+	ctx, finish, err := traceCommand(ctx, c, "run", started)
+	if err != nil {
+		return err
+	}
+	defer func() { finish(err) }()
+
+	provider, err := newCIProvider(ctx, c, l)
+	if err != nil {
+		return fmt.Errorf("unable to configure CI provider: %s", err)
+	}
+	metricsFrom(ctx).track(c, providerLabels(provider))
+
+	if err := validateConfig(c, provider, true); err != nil {
+		return err
+	}
+
+	return createBuild(ctx, c, provider)
+}
+
+// createCommand records a build using only values from the command line, so that no CI system is needed, e.g.
+// `action create -build-collector-host localhost:8082 -repository https://github.com/rode/demo-app -commit-id 123 -artifact-id app@sha256:456`
+func createCommand(ctx context.Context, args []string) (err error) {
+	started := time.Now()
+	flags := newFlagSet("create", "[flags]", "Records a build described entirely by flags, no CI system's API is called.")
+	provider := &manualProvider{}
+	flags.StringVar(&provider.Repository, "repository", "", "URL of the source repository (required)")
+	flags.StringVar(&provider.CommitId, "commit-id", "", "The commit that was built (required)")
+	flags.StringVar(&provider.CommitUri, "commit-uri", "", "URL of the commit")
+	flags.StringVar(&provider.Creator, "creator", "", "Who started the build")
+	flags.StringVar(&provider.LogsUri, "logs-uri", "", "URL of the build logs")
+	flags.StringVar(&provider.ProvenanceId, "provenance-id", "", "Identifier of the build, e.g. a URL to the build in the CI system")
+	flags.StringVar(&provider.BuilderId, "builder-id", "", "Identifier of the system that ran the build, used in provenance")
+	source := addConfigFlags(flags)
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	c, _, err := source.load(ctx)
+	if err != nil {
+		return err
+	}
+
+	ctx, finish, err := traceCommand(ctx, c, "create", started)
+	if err != nil {
+		return err
+	}
+	defer func() { finish(err) }()
+
+	// the build times are config flags, so that they can also be set from the environment or the config file
+	provider.BuildStart, provider.BuildEnd = c.BuildStart, c.BuildEnd
+	metricsFrom(ctx).track(c, providerLabels(provider))
+
+	if err := validateConfig(c, provider, true); err != nil {
+		return err
+	}
+
+	return createBuild(ctx, c, provider)
+}
+
+func updateArtifactsCommand(ctx context.Context, args []string) (err error) {
+	started := time.Now()
+	flags := newFlagSet("update-artifacts", "-existing-artifact-id <id> [flags]", "Adds the artifact described by the flags to the build occurrence of an existing artifact.")
+	existingArtifactId := flags.String("existing-artifact-id", "", "An artifact of the build occurrence to update (required)")
+	source := addConfigFlags(flags)
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	if *existingArtifactId == "" {
+		return fmt.Errorf("-existing-artifact-id is required")
+	}
+
+	c, _, err := source.load(ctx)
+	if err != nil {
+		return err
+	}
+
+	ctx, finish, err := traceCommand(ctx, c, "update-artifacts", started)
+	if err != nil {
+		return err
+	}
+	defer func() { finish(err) }()
+
+	if err := validateConfig(c, nil, true); err != nil {
+		return err
+	}
+
+	ctx, cancel := withTimeout(ctx, c.Timeout.Total)
+	defer cancel()
+
+	action, closeConn, err := newAction(ctx, c)
+	if err != nil {
+		return err
+	}
+	defer closeConn()
+
+	defer setOutputs(action)
+
+	occurrenceId, err := action.UpdateArtifacts(ctx, *existingArtifactId)
+	if err != nil {
+		return err
+	}
+	setOutputVariable("id", occurrenceId)
+
+	return nil
+}
+
+func replayCommand(ctx context.Context, args []string) (err error) {
+	started := time.Now()
+	flags := newFlagSet("replay", "-request <path> [flags]", "Sends a request that was saved with -request-path to the build collector again.")
+	requestPath := flags.String("request", "", "Path to the saved request (required)")
+	source := addConfigFlags(flags)
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	if *requestPath == "" {
+		return fmt.Errorf("-request is required")
+	}
+
+	request, err := readRequest(*requestPath)
+	if err != nil {
+		return err
+	}
+
+	c, _, err := source.load(ctx)
+	if err != nil {
+		return err
+	}
+
+	ctx, finish, err := traceCommand(ctx, c, "replay", started)
+	if err != nil {
+		return err
+	}
+	defer func() { finish(err) }()
+	metricsFrom(ctx).track(c, metricLabels{Repo: request.Repository})
+
+	if err := validateConfig(c, nil, false); err != nil {
+		return err
+	}
+
+	ctx, cancel := withTimeout(ctx, c.Timeout.Total)
+	defer cancel()
+	defer func() { metricsFrom(ctx).record(ctx, err) }()
+
+	action, closeConn, err := newAction(ctx, c)
+	if err != nil {
+		return err
+	}
+	defer closeConn()
+
+	defer setOutputs(action)
+
+	occurrenceId, err := action.Replay(ctx, request)
+	if occurrenceId != "" {
+		setOutputVariable("id", occurrenceId)
+	}
+
+	return err
+}
+
+func readRequest(path string) (*collector.CreateBuildRequest, error) {
+	contents, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read request: %s", err)
+	}
+
+	request := &collector.CreateBuildRequest{}
+	if err := protojson.Unmarshal(contents, request); err != nil {
+		return nil, fmt.Errorf("unable to parse request: %s", err)
+	}
+
+	return request, nil
+}
+
+// diagnoseCommand reports on each step of connecting to the build collector, e.g. `action diagnose -build-collector-host rode:443`
+func diagnoseCommand(ctx context.Context, args []string) error {
+	flags := newFlagSet("diagnose", "[flags]", "Resolves the build collector host, then checks the TCP connection, TLS handshake, gRPC health service and access token, timing each step.")
+	source := addConfigFlags(flags)
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	c, _, err := source.load(ctx)
+	if err != nil {
+		return err
+	}
+
+	targets, err := collectorTargets(c)
+	if err != nil {
+		return err
+	}
+
+	var failed []string
+	for i, target := range targets {
+		if len(targets) > 1 {
+			if i > 0 {
+				fmt.Println()
+			}
+			fmt.Printf("%s (%s):\n", target.Name, target.Host)
+		}
+
+		report := diagnoseCollector(ctx, c, target)
+		report.print(os.Stdout)
+		if report.failed() {
+			failed = append(failed, describeTarget(target, len(targets)))
+		}
+	}
+
+	if len(failed) > 0 {
+		return fmt.Errorf("unable to connect to %s", strings.Join(failed, ", "))
+	}
+
+	return nil
+}
+
+func validateConfigCommand(ctx context.Context, args []string) error {
+	flags := newFlagSet("validate-config", "[flags]", "Checks that the configuration is complete, without contacting the build collector.")
+	source := addConfigFlags(flags)
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	c, l, err := source.load(ctx)
+	if err != nil {
+		return err
+	}
+
+	provider, err := newCIProvider(ctx, c, l)
+	if err != nil {
+		return fmt.Errorf("unable to configure CI provider: %s", err)
+	}
+
+	if err := validateConfig(c, provider, true); err != nil {
+		return err
+	}
+
+	fmt.Println("Configuration is valid")
+
+	return nil
+}
+
+// verifyCommand checks the signature of an envelope written by the action, e.g. `action verify -envelope build.dsse.json -key cosign.pub`
+func verifyCommand(_ context.Context, args []string) error {
+	flags := newFlagSet("verify", "-key <path> [flags]", "Verifies the signature of a DSSE envelope written by the action.")
+	envelopePath := flags.String("envelope", "build.dsse.json", "Path to the DSSE envelope")
+	keyPath := flags.String("key", "", "Path to the PEM encoded public key or certificate (required)")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	if *keyPath == "" {
+		return fmt.Errorf("a verification key is required")
+	}
+
+	keyPem, err := os.ReadFile(*keyPath)
+	if err != nil {
+		return fmt.Errorf("unable to read verification key: %s", err)
+	}
+
+	publicKey, err := loadVerificationKey(keyPem)
+	if err != nil {
+		return err
+	}
+
+	contents, err := os.ReadFile(*envelopePath)
+	if err != nil {
+		return fmt.Errorf("unable to read envelope: %s", err)
+	}
+
+	envelope := &dsseEnvelope{}
+	if err := json.Unmarshal(contents, envelope); err != nil {
+		return fmt.Errorf("unable to parse envelope: %s", err)
+	}
+
+	if _, err := verifyEnvelope(envelope, publicKey); err != nil {
+		return fmt.Errorf("verification failed: %s", err)
+	}
+
+	fingerprint, _ := keyFingerprint(publicKey)
+	fmt.Printf("Verified %s envelope signed by %s\n", envelope.PayloadType, fingerprint)
+
+	return nil
+}
+
+func versionCommand(_ context.Context, _ []string) error {
+	fmt.Println(version)
+
+	return nil
+}
@@ -0,0 +1,99 @@
+// Copyright 2021 The Rode Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("commands", func() {
+	It("should list every command in the usage", func() {
+		output := &bytes.Buffer{}
+		commands := newCommands()
+
+		printUsage(output, commands)
+
+		for _, c := range commands {
+			Expect(output.String()).To(ContainSubstring("  " + c.name + " "))
+		}
+	})
+
+	It("should list every config layer in order of precedence", func() {
+		output := &bytes.Buffer{}
+
+		printUsage(output, newCommands())
+
+		Expect(output.String()).To(MatchRegexp(`(?s)Flags.*environment variables.*-env-file.*config file.*defaults`))
+		Expect(output.String()).To(ContainSubstring(defaultConfigFile))
+	})
+
+	Describe("readRequest", func() {
+		It("should parse a saved request", func() {
+			path := filepath.Join(tempDir(), "build.json")
+			Expect(os.WriteFile(path, []byte(`{"repository": "https://github.com/rode/demo-app", "commitId": "foobar", "artifacts": [{"id": "app@sha256:123"}]}`), 0644)).To(Succeed())
+
+			request, err := readRequest(path)
+
+			Expect(err).NotTo(HaveOccurred())
+			Expect(request.Repository).To(Equal("https://github.com/rode/demo-app"))
+			Expect(request.CommitId).To(Equal("foobar"))
+			Expect(request.Artifacts[0].Id).To(Equal("app@sha256:123"))
+		})
+
+		It("should return an error for an invalid request", func() {
+			path := filepath.Join(tempDir(), "build.json")
+			Expect(os.WriteFile(path, []byte(`{"repository": 1}`), 0644)).To(Succeed())
+
+			_, err := readRequest(path)
+
+			Expect(err).To(MatchError(ContainSubstring("unable to parse request")))
+		})
+	})
+
+	Describe("updateArtifactsCommand", func() {
+		It("should require the existing artifact id", func() {
+			err := updateArtifactsCommand(context.Background(), nil)
+
+			Expect(err).To(MatchError("-existing-artifact-id is required"))
+		})
+	})
+
+	Describe("exitCode", func() {
+		It("should exit with 1 for an error", func() {
+			Expect(exitCode(context.Background())).To(Equal(1))
+		})
+
+		It("should exit with a distinct code when the action was cancelled", func() {
+			ctx, cancel := context.WithCancel(context.Background())
+			cancel()
+
+			Expect(exitCode(ctx)).To(Equal(exitCancelled))
+		})
+	})
+
+	Describe("replayCommand", func() {
+		It("should require the request path", func() {
+			err := replayCommand(context.Background(), nil)
+
+			Expect(err).To(MatchError("-request is required"))
+		})
+	})
+})
@@ -0,0 +1,179 @@
+// Copyright 2021 The Rode Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"reflect"
+	"strings"
+	"time"
+	"unicode"
+
+	"gopkg.in/yaml.v3"
+)
+
+const defaultConfigFile = ".rode/build-occurrence.yaml"
+
+// readConfigFile loads shared defaults from a YAML file, returning the values keyed by their environment variable.
+// The file mirrors the config, e.g.
+//
+//	buildCollector:
+//	  host: rode-collector-build.example.com:443
+//	provenance:
+//	  version: v1
+//
+// A missing file is only an error when required is set.
+func readConfigFile(path string, required bool) (map[string]string, error) {
+	contents, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) && !required {
+		return map[string]string{}, nil
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("unable to read config file: %s", err)
+	}
+
+	document := &yaml.Node{}
+	if err := yaml.Unmarshal(contents, document); err != nil {
+		return nil, fmt.Errorf("%s: %s", path, err)
+	}
+
+	values := map[string]string{}
+	if len(document.Content) == 0 {
+		return values, nil
+	}
+
+	var problems []string
+	decodeConfigNode(document.Content[0], reflect.TypeOf(config{}), "", "", values, &problems)
+	if len(problems) > 0 {
+		return nil, fmt.Errorf("invalid config file:\n  %s:%s", path, strings.Join(problems, "\n  "+path+":"))
+	}
+
+	return values, nil
+}
+
+// decodeConfigNode checks a mapping in the config file against the fields of the struct, collecting the values and any problems
+func decodeConfigNode(node *yaml.Node, t reflect.Type, envPrefix, path string, values map[string]string, problems *[]string) {
+	if node.Kind != yaml.MappingNode {
+		*problems = append(*problems, fmt.Sprintf("%d: %s must be a mapping", node.Line, describeConfigPath(path)))
+		return
+	}
+
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		keyNode, valueNode := node.Content[i], node.Content[i+1]
+		field, ok := configFileField(t, keyNode.Value)
+		if !ok {
+			*problems = append(*problems, fmt.Sprintf("%d: unknown field %q in %s", keyNode.Line, keyNode.Value, describeConfigPath(path)))
+			continue
+		}
+
+		options := strings.Split(field.Tag.Get("env"), ",")
+		fieldPath := strings.TrimPrefix(path+"."+keyNode.Value, ".")
+		if field.Type.Kind() == reflect.Ptr && field.Type.Elem().Kind() == reflect.Struct {
+			nestedPrefix := ""
+			for _, option := range options[1:] {
+				if strings.HasPrefix(option, "prefix=") {
+					nestedPrefix = strings.TrimPrefix(option, "prefix=")
+				}
+			}
+
+			decodeConfigNode(valueNode, field.Type.Elem(), envPrefix+nestedPrefix, fieldPath, values, problems)
+			continue
+		}
+
+		if valueNode.Kind != yaml.ScalarNode {
+			*problems = append(*problems, fmt.Sprintf("%d: %s must be a %s", valueNode.Line, fieldPath, configFileType(field.Type)))
+			continue
+		}
+
+		if expected := configFileTag(field.Type); expected != "" && valueNode.Tag != expected {
+			*problems = append(*problems, fmt.Sprintf("%d: %s must be a %s, got %q", valueNode.Line, fieldPath, configFileType(field.Type), valueNode.Value))
+			continue
+		}
+
+		if field.Type == durationType {
+			if _, err := time.ParseDuration(valueNode.Value); err != nil {
+				*problems = append(*problems, fmt.Sprintf("%d: %s must be a duration, e.g. 30s, got %q", valueNode.Line, fieldPath, valueNode.Value))
+				continue
+			}
+		}
+
+		values[envPrefix+options[0]] = valueNode.Value
+	}
+}
+
+// configFileField finds the field of the struct that the key refers to, keys are the field names in lower camel case
+func configFileField(t reflect.Type, key string) (reflect.StructField, bool) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if _, ok := field.Tag.Lookup("env"); ok && configFileKey(field.Name) == key {
+			return field, true
+		}
+	}
+
+	return reflect.StructField{}, false
+}
+
+func configFileKey(fieldName string) string {
+	runes := []rune(fieldName)
+	runes[0] = unicode.ToLower(runes[0])
+
+	return string(runes)
+}
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+// configFileTag is the YAML tag that values for the type must resolve to, strings and durations accept any scalar
+func configFileTag(t reflect.Type) string {
+	if t == durationType {
+		return ""
+	}
+
+	switch t.Kind() {
+	case reflect.Bool:
+		return "!!bool"
+	case reflect.Int, reflect.Int64:
+		return "!!int"
+	}
+
+	return ""
+}
+
+func configFileType(t reflect.Type) string {
+	if t == durationType {
+		return "duration"
+	}
+
+	switch t.Kind() {
+	case reflect.Bool:
+		return "boolean"
+	case reflect.Int, reflect.Int64:
+		return "number"
+	case reflect.Ptr:
+		return "mapping"
+	}
+
+	return "string"
+}
+
+func describeConfigPath(path string) string {
+	if path == "" {
+		return "the config file"
+	}
+
+	return path
+}
@@ -0,0 +1,142 @@
+// Copyright 2021 The Rode Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("readConfigFile", func() {
+	var (
+		path     string
+		contents string
+		required bool
+
+		actualValues map[string]string
+		actualError  error
+	)
+
+	BeforeEach(func() {
+		path = filepath.Join(tempDir(), "build-occurrence.yaml")
+		required = false
+		contents = `# shared defaults for the organization
+buildCollector:
+  host: rode-collector-build.example.com:443
+  insecure: false
+artifactNamesDelimiter: ","
+provenance:
+  path: provenance.json
+  attach: true
+`
+	})
+
+	JustBeforeEach(func() {
+		if contents != "" {
+			Expect(os.WriteFile(path, []byte(contents), 0644)).To(Succeed())
+		}
+
+		actualValues, actualError = readConfigFile(path, required)
+	})
+
+	It("should return the values by environment variable", func() {
+		Expect(actualError).NotTo(HaveOccurred())
+		Expect(actualValues).To(Equal(map[string]string{
+			"BUILD_COLLECTOR_HOST":     "rode-collector-build.example.com:443",
+			"BUILD_COLLECTOR_INSECURE": "false",
+			"ARTIFACT_NAMES_DELIMITER": ",",
+			"PROVENANCE_PATH":          "provenance.json",
+			"PROVENANCE_ATTACH":        "true",
+		}))
+	})
+
+	When("the file doesn't match the schema", func() {
+		BeforeEach(func() {
+			contents = `buildCollector:
+  hots: localhost:8082
+  insecure: maybe
+provenance: v1
+signing:
+  key:
+    - one
+artifactVersion: 1.2
+`
+		})
+
+		It("should return every problem with its line number", func() {
+			Expect(actualError).To(HaveOccurred())
+			Expect(actualError.Error()).To(Equal("invalid config file:\n" +
+				"  " + path + `:2: unknown field "hots" in buildCollector` + "\n" +
+				"  " + path + `:3: buildCollector.insecure must be a boolean, got "maybe"` + "\n" +
+				"  " + path + ":4: provenance must be a mapping\n" +
+				"  " + path + ":7: signing.key must be a string"))
+		})
+	})
+
+	When("there are timeouts", func() {
+		BeforeEach(func() {
+			contents = "timeout:\n  dial: 10s\n  rpc: 1m30s\n  total: soon\n"
+		})
+
+		It("should check that they're durations", func() {
+			Expect(actualError).To(HaveOccurred())
+			Expect(actualError.Error()).To(Equal("invalid config file:\n  " + path + `:4: timeout.total must be a duration, e.g. 30s, got "soon"`))
+		})
+	})
+
+	When("the file is not valid YAML", func() {
+		BeforeEach(func() {
+			contents = "buildCollector:\n  host: [localhost\n"
+		})
+
+		It("should return an error that includes the path", func() {
+			Expect(actualError).To(MatchError(ContainSubstring(path + ": yaml: line")))
+		})
+	})
+
+	When("the top level is not a mapping", func() {
+		BeforeEach(func() {
+			contents = "- host: localhost:8082\n"
+		})
+
+		It("should return an error", func() {
+			Expect(actualError).To(MatchError(ContainSubstring(":1: the config file must be a mapping")))
+		})
+	})
+
+	When("the file doesn't exist", func() {
+		BeforeEach(func() {
+			contents = ""
+		})
+
+		It("should not return any values", func() {
+			Expect(actualError).NotTo(HaveOccurred())
+			Expect(actualValues).To(BeEmpty())
+		})
+
+		When("the file was set explicitly", func() {
+			BeforeEach(func() {
+				required = true
+			})
+
+			It("should return an error", func() {
+				Expect(actualError).To(MatchError(ContainSubstring("unable to read config file")))
+			})
+		})
+	})
+})
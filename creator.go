@@ -0,0 +1,103 @@
+// Copyright 2021 The Rode Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"text/template"
+)
+
+// the sources that the creator of the build can be resolved from. Only actor and template are available outside of GitHub Actions.
+const (
+	creatorSourceActor     = "actor"
+	creatorSourceAuthor    = "author"
+	creatorSourceCommitter = "committer"
+	creatorSourceEmail     = "email"
+	creatorSourceId        = "id"
+	creatorSourceTemplate  = "template"
+)
+
+var creatorSources = []string{creatorSourceActor, creatorSourceAuthor, creatorSourceCommitter, creatorSourceEmail, creatorSourceId, creatorSourceTemplate}
+
+// creatorTemplateData is what's available to CREATOR_TEMPLATE, e.g. {{.Actor}}@example.com or {{.Env.github_triggering_actor}}
+type creatorTemplateData struct {
+	Actor      string
+	CommitId   string
+	Repository string
+	Env        map[string]interface{}
+}
+
+func parseCreatorTemplate(text string) (*template.Template, error) {
+	return template.New("creator").Option("missingkey=error").Parse(text)
+}
+
+// renderCreator executes the template against the build, the result must not be empty
+func renderCreator(text string, build *buildMetadata) (string, error) {
+	tmpl, err := parseCreatorTemplate(text)
+	if err != nil {
+		return "", err
+	}
+
+	data := &creatorTemplateData{
+		Actor:      build.Actor,
+		CommitId:   build.CommitId,
+		Repository: build.Repository,
+	}
+	if build.Invocation != nil {
+		data.Env = build.Invocation.Environment
+	}
+
+	var creator bytes.Buffer
+	if err := tmpl.Execute(&creator, data); err != nil {
+		return "", err
+	}
+
+	if creator.Len() == 0 {
+		return "", fmt.Errorf("the template %q rendered an empty creator", text)
+	}
+
+	return creator.String(), nil
+}
+
+func validateCreator(c *config, provider ciProvider) configErrors {
+	var problems configErrors
+	switch c.CreatorSource {
+	case "", creatorSourceActor:
+	case creatorSourceTemplate:
+		if c.CreatorTemplate == "" {
+			problems = append(problems, "CREATOR_SOURCE: the template source requires CREATOR_TEMPLATE to be set")
+		}
+	case creatorSourceAuthor, creatorSourceCommitter, creatorSourceEmail, creatorSourceId:
+		if _, ok := provider.(*githubProvider); provider != nil && !ok {
+			problems = append(problems, fmt.Sprintf("CREATOR_SOURCE: %s is only available in GitHub Actions, use a template instead", c.CreatorSource))
+		}
+	default:
+		problems = append(problems, fmt.Sprintf("CREATOR_SOURCE: %q is not supported, expected one of %s", c.CreatorSource, strings.Join(creatorSources, ", ")))
+	}
+
+	if c.CreatorTemplate != "" {
+		if c.CreatorSource != creatorSourceTemplate {
+			problems = append(problems, "CREATOR_TEMPLATE: requires CREATOR_SOURCE to be template")
+		}
+
+		if _, err := parseCreatorTemplate(c.CreatorTemplate); err != nil {
+			problems = append(problems, fmt.Sprintf("CREATOR_TEMPLATE: %s", err))
+		}
+	}
+
+	return problems
+}
@@ -0,0 +1,79 @@
+// Copyright 2021 The Rode Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("creator", func() {
+	var build *buildMetadata
+
+	BeforeEach(func() {
+		build = &buildMetadata{
+			Actor:      "jdoe",
+			CommitId:   "foobar",
+			Repository: "https://github.com/rode/demo-app",
+			Invocation: &buildInvocation{
+				Environment: map[string]interface{}{"github_triggering_actor": "octocat"},
+			},
+		}
+	})
+
+	DescribeTable("renderCreator",
+		func(text, expected string) {
+			creator, err := renderCreator(text, build)
+
+			Expect(err).NotTo(HaveOccurred())
+			Expect(creator).To(Equal(expected))
+		},
+		Entry("actor", "{{.Actor}}@example.com", "jdoe@example.com"),
+		Entry("environment", "{{.Env.github_triggering_actor}}", "octocat"),
+		Entry("commit", "{{.Repository}}/commit/{{.CommitId}}", "https://github.com/rode/demo-app/commit/foobar"),
+	)
+
+	DescribeTable("invalid templates",
+		func(text, expected string) {
+			_, err := renderCreator(text, build)
+
+			Expect(err).To(MatchError(ContainSubstring(expected)))
+		},
+		Entry("missing environment variable", "{{.Env.gitlab_user_login}}", `map has no entry for key "gitlab_user_login"`),
+		Entry("unknown field", "{{.Email}}", "can't evaluate field Email"),
+		Entry("empty result", `{{""}}`, "rendered an empty creator"),
+	)
+
+	DescribeTable("validateCreator",
+		func(source, template string, provider ciProvider, expected ...string) {
+			conf := &config{CreatorSource: source, CreatorTemplate: template}
+
+			if len(expected) == 0 {
+				Expect(validateCreator(conf, provider)).To(BeEmpty())
+			} else {
+				Expect(validateCreator(conf, provider)).To(Equal(configErrors(expected)))
+			}
+		},
+		Entry("actor", creatorSourceActor, "", &manualProvider{}),
+		Entry("email in GitHub Actions", creatorSourceEmail, "", &githubProvider{}),
+		Entry("template", creatorSourceTemplate, "{{.Actor}}", &manualProvider{}),
+		Entry("unsupported source", "login", "", nil, `CREATOR_SOURCE: "login" is not supported, expected one of actor, author, committer, email, id, template`),
+		Entry("API source outside of GitHub Actions", creatorSourceAuthor, "", &manualProvider{}, "CREATOR_SOURCE: author is only available in GitHub Actions, use a template instead"),
+		Entry("template source without a template", creatorSourceTemplate, "", nil, "CREATOR_SOURCE: the template source requires CREATOR_TEMPLATE to be set"),
+		Entry("template without the template source", creatorSourceActor, "{{.Actor}}", nil, "CREATOR_TEMPLATE: requires CREATOR_SOURCE to be template"),
+		Entry("malformed template", creatorSourceTemplate, "{{.Actor", nil, `CREATOR_TEMPLATE: template: creator:1: unclosed action`),
+	)
+})
@@ -0,0 +1,307 @@
+// Copyright 2021 The Rode Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+)
+
+// diagnosticStep is the result of one phase of connecting to the build collector
+type diagnosticStep struct {
+	Name     string
+	Duration time.Duration
+	Details  []string
+	Err      error
+	Skipped  bool
+}
+
+type diagnosticReport []*diagnosticStep
+
+func (r diagnosticReport) failed() bool {
+	for _, step := range r {
+		if step.Err != nil {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (r diagnosticReport) print(w io.Writer) {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	for _, step := range r {
+		result := "ok"
+		switch {
+		case step.Skipped:
+			result = "skipped"
+		case step.Err != nil:
+			result = "failed"
+		}
+
+		summary := ""
+		if step.Err != nil {
+			summary = step.Err.Error()
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%dms\t%s\n", step.Name, result, step.Duration.Milliseconds(), summary)
+		for _, detail := range step.Details {
+			fmt.Fprintf(tw, "\t\t\t%s\n", detail)
+		}
+	}
+	tw.Flush()
+}
+
+func (r diagnosticReport) String() string {
+	var report strings.Builder
+	r.print(&report)
+
+	return report.String()
+}
+
+// diagnoseCollector connects to the build collector one phase at a time: resolving the host, opening a TCP connection,
+// the TLS handshake, the gRPC health service, then the credentials. Once a phase fails, the rest are skipped.
+func diagnoseCollector(ctx context.Context, c *config, t *collectorTarget) diagnosticReport {
+	host, port, err := net.SplitHostPort(t.Host)
+	if err != nil {
+		return diagnosticReport{{Name: "dns", Err: fmt.Errorf("%q must be in the form host:port", t.Host)}}
+	}
+
+	var addresses []string
+	// anonymous is the outcome of the health check made without the access token, which tells whether checking the token means anything
+	var anonymous *status.Status
+	phases := []struct {
+		name    string
+		timeout time.Duration
+		run     func(ctx context.Context, step *diagnosticStep) error
+	}{
+		{"dns", c.Timeout.Dial, func(ctx context.Context, step *diagnosticStep) error {
+			resolved, err := net.DefaultResolver.LookupHost(ctx, host)
+			if err != nil {
+				return err
+			}
+			addresses = resolved
+			step.Details = []string{fmt.Sprintf("%s resolved to %s", host, strings.Join(addresses, ", "))}
+
+			return nil
+		}},
+		{"tcp", c.Timeout.Dial, func(ctx context.Context, step *diagnosticStep) error {
+			return diagnoseTCP(ctx, step, addresses, port)
+		}},
+		{"tls", c.Timeout.Dial, func(ctx context.Context, step *diagnosticStep) error {
+			if t.Insecure {
+				step.Skipped = true
+				step.Details = []string{t.setting("insecure") + " is set"}
+				return nil
+			}
+
+			return diagnoseTLS(ctx, step, t, host)
+		}},
+		{"grpc", c.Timeout.Rpc, func(ctx context.Context, step *diagnosticStep) (err error) {
+			anonymous, err = diagnoseHealth(ctx, step, t)
+			return err
+		}},
+		{"auth", c.Timeout.Rpc, func(ctx context.Context, step *diagnosticStep) error {
+			return diagnoseAuth(ctx, step, t, anonymous)
+		}},
+	}
+
+	var report diagnosticReport
+	for _, phase := range phases {
+		step := &diagnosticStep{Name: phase.name}
+		report = append(report, step)
+		if report[:len(report)-1].failed() {
+			step.Skipped = true
+			continue
+		}
+
+		phaseCtx, cancel := withTimeout(ctx, phase.timeout)
+		start := time.Now()
+		step.Err = phase.run(phaseCtx, step)
+		step.Duration = time.Since(start)
+		cancel()
+	}
+
+	return report
+}
+
+func diagnoseTCP(ctx context.Context, step *diagnosticStep, addresses []string, port string) error {
+	var dialer net.Dialer
+	var err error
+	for _, address := range addresses {
+		var conn net.Conn
+		conn, err = dialer.DialContext(ctx, "tcp", net.JoinHostPort(address, port))
+		if err == nil {
+			step.Details = append(step.Details, fmt.Sprintf("connected to %s", conn.RemoteAddr()))
+			return conn.Close()
+		}
+		step.Details = append(step.Details, err.Error())
+	}
+
+	return fmt.Errorf("unable to connect to any address on port %s", port)
+}
+
+// diagnoseTLS completes the handshake without verifying the certificate, so that the chain can be printed even when it isn't trusted
+func diagnoseTLS(ctx context.Context, step *diagnosticStep, t *collectorTarget, serverName string) error {
+	tlsConfig, err := t.tlsConfig()
+	if err != nil {
+		return err
+	}
+
+	dialer := &tls.Dialer{Config: &tls.Config{ServerName: serverName, InsecureSkipVerify: true}}
+	conn, err := dialer.DialContext(ctx, "tcp", t.Host)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	state := conn.(*tls.Conn).ConnectionState()
+	step.Details = append(step.Details, fmt.Sprintf("%s, %s", tlsVersionName(state.Version), tls.CipherSuiteName(state.CipherSuite)))
+	for i, cert := range state.PeerCertificates {
+		step.Details = append(step.Details, fmt.Sprintf("certificate %d: %s, issued by %s, expires %s", i, cert.Subject, cert.Issuer, cert.NotAfter.Format(time.RFC3339)))
+		if sans := certificateSANs(cert); len(sans) > 0 {
+			step.Details = append(step.Details, fmt.Sprintf("  SANs: %s", strings.Join(sans, ", ")))
+		}
+	}
+
+	if len(state.PeerCertificates) == 0 {
+		return fmt.Errorf("the server didn't present a certificate")
+	}
+
+	intermediates := x509.NewCertPool()
+	for _, cert := range state.PeerCertificates[1:] {
+		intermediates.AddCert(cert)
+	}
+
+	_, err = state.PeerCertificates[0].Verify(x509.VerifyOptions{DNSName: serverName, Intermediates: intermediates, Roots: tlsConfig.RootCAs})
+
+	return err
+}
+
+// diagnoseHealth checks the health service without the access token, so that diagnoseAuth can tell whether the build collector
+// authenticates health checks at all. The status of the check is returned along with any error for the step.
+func diagnoseHealth(ctx context.Context, step *diagnosticStep, t *collectorTarget) (*status.Status, error) {
+	withoutToken := *t
+	withoutToken.AccessToken = ""
+	conn, err := dialTarget(ctx, &withoutToken)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	response, err := healthpb.NewHealthClient(conn).Check(ctx, &healthpb.HealthCheckRequest{})
+	switch status.Code(err) {
+	case codes.OK:
+		step.Details = []string{fmt.Sprintf("health service reports %s", response.Status)}
+		if response.Status != healthpb.HealthCheckResponse_SERVING {
+			return nil, fmt.Errorf("the build collector is %s", response.Status)
+		}
+	case codes.Unimplemented:
+		step.Details = []string{"connected, the build collector doesn't have a health service"}
+	case codes.Unauthenticated, codes.PermissionDenied:
+		step.Details = []string{"connected, the health service requires authentication"}
+	default:
+		return nil, err
+	}
+
+	return status.Convert(err), nil
+}
+
+// diagnoseAuth checks the credentials with the health service rather than a build collector request, because the only requests
+// the build collector serves are writes. That only shows the token is accepted when the health service turned away the check
+// without it, otherwise the build collector doesn't authenticate health checks and the token is reported as unverified.
+func diagnoseAuth(ctx context.Context, step *diagnosticStep, t *collectorTarget, anonymous *status.Status) error {
+	authenticated := anonymous.Code() == codes.Unauthenticated || anonymous.Code() == codes.PermissionDenied
+	switch {
+	case anonymous.Code() == codes.Unimplemented:
+		step.Skipped = true
+		step.Details = []string{"the build collector doesn't have a health service to check the credentials with"}
+		return nil
+	case t.AccessToken == "" && authenticated:
+		return fmt.Errorf("the build collector requires an access token: %s", anonymous.Message())
+	case t.AccessToken == "":
+		step.Skipped = true
+		step.Details = []string{"the health service doesn't require an access token, so it can't tell whether the build collector does"}
+		return nil
+	}
+
+	conn, err := dialTarget(ctx, t)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	_, err = healthpb.NewHealthClient(conn).Check(ctx, &healthpb.HealthCheckRequest{})
+	switch status.Code(err) {
+	case codes.OK:
+	case codes.Unauthenticated, codes.PermissionDenied:
+		return fmt.Errorf("the access token was rejected: %s", status.Convert(err).Message())
+	default:
+		return err
+	}
+
+	if !authenticated {
+		step.Skipped = true
+		step.Details = []string{"the health service doesn't require an access token, so the access token is unverified"}
+		return nil
+	}
+	step.Details = []string{"the access token was accepted"}
+
+	return nil
+}
+
+func dialTarget(ctx context.Context, t *collectorTarget) (*grpc.ClientConn, error) {
+	dialOptions, err := buildCollectorDialOptions(t)
+	if err != nil {
+		return nil, err
+	}
+
+	return grpc.DialContext(ctx, t.Host, dialOptions...)
+}
+
+func certificateSANs(cert *x509.Certificate) []string {
+	sans := append([]string{}, cert.DNSNames...)
+	for _, ip := range cert.IPAddresses {
+		sans = append(sans, ip.String())
+	}
+
+	return sans
+}
+
+func tlsVersionName(version uint16) string {
+	switch version {
+	case tls.VersionTLS10:
+		return "TLS 1.0"
+	case tls.VersionTLS11:
+		return "TLS 1.1"
+	case tls.VersionTLS12:
+		return "TLS 1.2"
+	case tls.VersionTLS13:
+		return "TLS 1.3"
+	}
+
+	return fmt.Sprintf("TLS 0x%04x", version)
+}
@@ -0,0 +1,269 @@
+// Copyright 2021 The Rode Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"net"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	collector "github.com/rode/collector-build/proto/v1alpha1"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// writeCountingCollector counts the requests that would have recorded something, which a diagnosis must never send
+type writeCountingCollector struct {
+	collector.UnimplementedBuildCollectorServer
+	writes int32
+}
+
+func (w *writeCountingCollector) CreateBuild(context.Context, *collector.CreateBuildRequest) (*collector.CreateBuildResponse, error) {
+	atomic.AddInt32(&w.writes, 1)
+	return &collector.CreateBuildResponse{}, nil
+}
+
+func (w *writeCountingCollector) UpdateBuildArtifacts(context.Context, *collector.UpdateBuildArtifactsRequest) (*collector.UpdateBuildArtifactsResponse, error) {
+	atomic.AddInt32(&w.writes, 1)
+	return &collector.UpdateBuildArtifactsResponse{}, nil
+}
+
+// tokenInterceptor rejects any request, including health checks, that doesn't have the expected token
+func tokenInterceptor(token string) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, _ *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		md, _ := metadata.FromIncomingContext(ctx)
+		if len(md.Get("authorization")) == 0 || md.Get("authorization")[0] != "Bearer "+token {
+			return nil, status.Error(codes.Unauthenticated, "invalid token")
+		}
+
+		return handler(ctx, req)
+	}
+}
+
+func selfSignedCertificate() tls.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	Expect(err).NotTo(HaveOccurred())
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "rode-collector-build"},
+		DNSNames:     []string{"localhost"},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	Expect(err).NotTo(HaveOccurred())
+
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+var _ = Describe("diagnoseCollector", func() {
+	var (
+		ctx        context.Context
+		conf       *config
+		server     *grpc.Server
+		serverOpts []grpc.ServerOption
+		collectors *writeCountingCollector
+		withHealth bool
+		token      string
+
+		actualReport diagnosticReport
+	)
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		conf = &config{
+			BuildCollector: &buildCollectorConfig{Insecure: true},
+			Timeout:        &timeoutConfig{Dial: time.Second, Rpc: time.Second},
+		}
+		serverOpts = nil
+		withHealth = true
+		token = ""
+	})
+
+	JustBeforeEach(func() {
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		Expect(err).NotTo(HaveOccurred())
+
+		if token != "" {
+			serverOpts = append(serverOpts, grpc.UnaryInterceptor(tokenInterceptor(token)))
+		}
+		server = grpc.NewServer(serverOpts...)
+		collectors = &writeCountingCollector{}
+		collector.RegisterBuildCollectorServer(server, collectors)
+		if withHealth {
+			healthpb.RegisterHealthServer(server, health.NewServer())
+		}
+		go server.Serve(listener)
+
+		if conf.BuildCollector.Host == "" {
+			conf.BuildCollector.Host = listener.Addr().String()
+		}
+		actualReport = diagnoseCollector(ctx, conf, defaultCollectorTarget(conf))
+	})
+
+	AfterEach(func() {
+		server.Stop()
+	})
+
+	It("should check each step", func() {
+		Expect(actualReport.failed()).To(BeFalse())
+		Expect(actualReport).To(HaveLen(5))
+
+		var names []string
+		for _, step := range actualReport {
+			names = append(names, step.Name)
+		}
+		Expect(names).To(Equal([]string{"dns", "tcp", "tls", "grpc", "auth"}))
+		Expect(actualReport[2].Skipped).To(BeTrue())
+		Expect(actualReport[3].Details).To(ConsistOf("health service reports SERVING"))
+		Expect(actualReport[4].Skipped).To(BeTrue())
+		Expect(actualReport[4].Details).To(ConsistOf("the health service doesn't require an access token, so it can't tell whether the build collector does"))
+	})
+
+	It("should not write to the build collector", func() {
+		Expect(atomic.LoadInt32(&collectors.writes)).To(BeZero())
+	})
+
+	It("should print the timing of each step", func() {
+		Expect(actualReport.String()).To(MatchRegexp(`(?m)^tcp\s+ok\s+\d+ms\s*$`))
+		Expect(actualReport.String()).To(MatchRegexp(`(?m)^tls\s+skipped`))
+	})
+
+	When("there is no health service", func() {
+		BeforeEach(func() {
+			withHealth = false
+		})
+
+		It("should still succeed", func() {
+			Expect(actualReport.failed()).To(BeFalse())
+			Expect(actualReport[3].Details).To(ConsistOf("connected, the build collector doesn't have a health service"))
+		})
+
+		It("should skip checking the credentials", func() {
+			Expect(actualReport[4].Skipped).To(BeTrue())
+			Expect(actualReport[4].Details).To(ConsistOf("the build collector doesn't have a health service to check the credentials with"))
+		})
+	})
+
+	When("an access token is required", func() {
+		BeforeEach(func() {
+			token = "secret"
+		})
+
+		It("should report that the token is missing", func() {
+			Expect(actualReport[4].Err).To(MatchError("the build collector requires an access token: invalid token"))
+		})
+
+		It("should report a rejected token", func() {
+			conf.AccessToken = "wrong"
+			actualReport = diagnoseCollector(ctx, conf, defaultCollectorTarget(conf))
+
+			Expect(actualReport[3].Err).NotTo(HaveOccurred())
+			Expect(actualReport[4].Err).To(MatchError("the access token was rejected: invalid token"))
+		})
+
+		It("should accept the right token", func() {
+			conf.AccessToken = "secret"
+			actualReport = diagnoseCollector(ctx, conf, defaultCollectorTarget(conf))
+
+			Expect(actualReport.failed()).To(BeFalse())
+			Expect(actualReport[4].Details).To(ConsistOf("the access token was accepted"))
+			Expect(atomic.LoadInt32(&collectors.writes)).To(BeZero())
+		})
+	})
+
+	When("only the build collector requests are authenticated", func() {
+		BeforeEach(func() {
+			serverOpts = append(serverOpts, grpc.UnaryInterceptor(func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+				if strings.HasPrefix(info.FullMethod, "/grpc.health.v1.Health/") {
+					return handler(ctx, req)
+				}
+
+				return tokenInterceptor("secret")(ctx, req, info, handler)
+			}))
+			conf.AccessToken = "wrong"
+		})
+
+		It("should report the token as unverified rather than accepted", func() {
+			Expect(actualReport.failed()).To(BeFalse())
+			Expect(actualReport[4].Skipped).To(BeTrue())
+			Expect(actualReport[4].Details).To(ConsistOf("the health service doesn't require an access token, so the access token is unverified"))
+		})
+	})
+
+	When("nothing is listening", func() {
+		BeforeEach(func() {
+			listener, err := net.Listen("tcp", "127.0.0.1:0")
+			Expect(err).NotTo(HaveOccurred())
+			conf.BuildCollector.Host = listener.Addr().String()
+			Expect(listener.Close()).To(Succeed())
+		})
+
+		It("should fail to connect and skip the rest", func() {
+			Expect(actualReport[0].Err).NotTo(HaveOccurred())
+			Expect(actualReport[1].Err).To(MatchError(ContainSubstring("unable to connect to any address")))
+			Expect(actualReport[1].Details).To(ContainElement(ContainSubstring("connection refused")))
+			for _, step := range actualReport[2:] {
+				Expect(step.Skipped).To(BeTrue())
+			}
+		})
+	})
+
+	When("the host isn't a host and port", func() {
+		BeforeEach(func() {
+			conf.BuildCollector.Host = "rode"
+		})
+
+		It("should fail", func() {
+			Expect(actualReport).To(HaveLen(1))
+			Expect(actualReport.failed()).To(BeTrue())
+		})
+	})
+
+	When("the certificate isn't trusted", func() {
+		BeforeEach(func() {
+			conf.BuildCollector.Insecure = false
+			serverOpts = []grpc.ServerOption{grpc.Creds(credentials.NewServerTLSFromCert(&[]tls.Certificate{selfSignedCertificate()}[0]))}
+		})
+
+		It("should print the certificate chain", func() {
+			tlsStep := actualReport[2]
+
+			Expect(tlsStep.Err).To(MatchError(ContainSubstring("certificate signed by unknown authority")))
+			Expect(tlsStep.Details[0]).To(HavePrefix("TLS 1.3"))
+			Expect(tlsStep.Details).To(ContainElement(HavePrefix("certificate 0: CN=rode-collector-build, issued by CN=rode-collector-build")))
+			Expect(tlsStep.Details).To(ContainElement("  SANs: localhost, 127.0.0.1"))
+			Expect(actualReport[3].Skipped).To(BeTrue())
+		})
+	})
+})
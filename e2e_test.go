@@ -0,0 +1,237 @@
+// Copyright 2021 The Rode Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+)
+
+// runAction runs a command the way main does, and returns what it printed, e.g. the step outputs, along with its error
+func runAction(args ...string) (string, error) {
+	var run func(context.Context, []string) error
+	for _, c := range newCommands() {
+		if c.name == args[0] {
+			run = c.run
+		}
+	}
+	Expect(run).NotTo(BeNil(), "unknown command %q", args[0])
+
+	r, w, err := os.Pipe()
+	Expect(err).NotTo(HaveOccurred())
+	output := make(chan string)
+	go func() {
+		contents, _ := io.ReadAll(r)
+		output <- string(contents)
+	}()
+
+	stdout := os.Stdout
+	os.Stdout = w
+	defer func() { os.Stdout = stdout }()
+
+	err = run(withMetrics(context.Background(), newBuildMetrics()), args[1:])
+	w.Close()
+
+	return <-output, err
+}
+
+var _ = Describe("end to end", func() {
+	var (
+		buildCollector *fakeCollector
+		connectionArgs []string
+	)
+
+	BeforeEach(func() {
+		buildCollector = &fakeCollector{id: "build-1", token: "secret"}
+		buildCollector.start(true)
+		connectionArgs = []string{
+			"-build-collector-host", buildCollector.host,
+			"-build-collector-ca-file", buildCollector.caFile,
+			"-access-token", "secret",
+		}
+	})
+
+	AfterEach(func() {
+		buildCollector.stop()
+	})
+
+	create := func(args ...string) (string, error) {
+		return runAction(append(append([]string{"create",
+			"-repository", "https://github.com/rode/demo-app",
+			"-commit-id", "foobar",
+			"-artifact-id", "harbor.example.com/rode/app@sha256:123",
+		}, connectionArgs...), args...)...)
+	}
+
+	Describe("create", func() {
+		It("should record the build over TLS with the access token", func() {
+			output, err := create()
+
+			Expect(err).NotTo(HaveOccurred())
+			Expect(output).To(ContainSubstring("::set-output name=id::build-1\n"))
+			Expect(output).To(ContainSubstring(`::set-output name=ids::{"default":"build-1"}` + "\n"))
+			requests := buildCollector.receivedCreates()
+			Expect(requests).To(HaveLen(1))
+			Expect(requests[0].Repository).To(Equal("https://github.com/rode/demo-app"))
+			Expect(requests[0].CommitId).To(Equal("foobar"))
+			Expect(requests[0].Artifacts[0].Id).To(Equal("harbor.example.com/rode/app@sha256:123"))
+		})
+
+		It("should be rejected with the wrong access token", func() {
+			_, err := create("-access-token", "wrong")
+
+			Expect(err).To(MatchError(ContainSubstring("error creating build occurrence: rpc error: code = Unauthenticated desc = invalid token")))
+			Expect(buildCollector.receivedCreates()).To(BeEmpty())
+		})
+
+		It("should refuse a certificate that isn't trusted", func() {
+			_, err := create("-build-collector-ca-file", "", "-timeout-dial", "100ms")
+
+			Expect(err).To(MatchError(ContainSubstring("certificate signed by unknown authority")))
+			Expect(buildCollector.receivedCreates()).To(BeEmpty())
+		})
+
+		It("should report the failure when the build collector returns an error", func() {
+			buildCollector.failNext(status.Error(codes.Unavailable, "the build collector is restarting"))
+
+			output, err := create()
+
+			Expect(err).To(MatchError("error creating build occurrence: rpc error: code = Unavailable desc = the build collector is restarting"))
+			Expect(output).NotTo(ContainSubstring("name=id::"))
+		})
+
+		It("should send the token in plain text to a local build collector", func() {
+			local := &fakeCollector{id: "local-1", token: "secret"}
+			local.start(false)
+			defer local.stop()
+
+			output, err := create("-build-collector-host", local.host, "-build-collector-insecure", "-build-collector-ca-file", "")
+
+			Expect(err).NotTo(HaveOccurred())
+			Expect(output).To(ContainSubstring("::set-output name=id::local-1\n"))
+			Expect(local.receivedCreates()).To(HaveLen(1))
+		})
+
+		It("should record the build in each of the build collectors", func() {
+			staging := &fakeCollector{id: "staging-1"}
+			staging.start(false)
+			defer staging.stop()
+			staging.failNext(status.Error(codes.Internal, "elasticsearch is unavailable"))
+			targets, err := json.Marshal([]map[string]interface{}{
+				{"name": "staging", "host": staging.host, "insecure": true},
+				{"name": "prod", "host": buildCollector.host, "caFile": buildCollector.caFile, "accessToken": "secret"},
+			})
+			Expect(err).NotTo(HaveOccurred())
+
+			output, err := runAction("create",
+				"-repository", "https://github.com/rode/demo-app",
+				"-commit-id", "foobar",
+				"-artifact-id", "harbor.example.com/rode/app@sha256:123",
+				"-build-collectors", string(targets),
+				"-partial-failure", "warn",
+			)
+
+			Expect(err).NotTo(HaveOccurred())
+			Expect(output).To(ContainSubstring("::set-output name=id::build-1\n"))
+			Expect(output).To(ContainSubstring(`::set-output name=ids::{"prod":"build-1"}` + "\n"))
+			Expect(buildCollector.receivedCreates()).To(HaveLen(1))
+		})
+	})
+
+	It("should replay a saved request", func() {
+		requestPath := filepath.Join(tempDir(), "request.json")
+		_, err := create("-request-path", requestPath)
+		Expect(err).NotTo(HaveOccurred())
+
+		output, err := runAction(append([]string{"replay", "-request", requestPath}, connectionArgs...)...)
+
+		Expect(err).NotTo(HaveOccurred())
+		Expect(output).To(ContainSubstring("::set-output name=id::build-1\n"))
+		requests := buildCollector.receivedCreates()
+		Expect(requests).To(HaveLen(2))
+		Expect(proto.Equal(requests[0], requests[1])).To(BeTrue())
+	})
+
+	It("should add an artifact to an existing build occurrence", func() {
+		output, err := runAction(append([]string{"update-artifacts",
+			"-existing-artifact-id", "harbor.example.com/rode/app@sha256:123",
+			"-artifact-id", "harbor.example.com/rode/app-debug@sha256:456",
+		}, connectionArgs...)...)
+
+		Expect(err).NotTo(HaveOccurred())
+		Expect(output).To(ContainSubstring("::set-output name=id::build-1\n"))
+		requests := buildCollector.receivedUpdates()
+		Expect(requests).To(HaveLen(1))
+		Expect(requests[0].ExistingArtifactId).To(Equal("harbor.example.com/rode/app@sha256:123"))
+		Expect(requests[0].NewArtifact.Id).To(Equal("harbor.example.com/rode/app-debug@sha256:456"))
+	})
+
+	Describe("on Jenkins", func() {
+		// the process environment takes precedence over the env file, so the variables of a Jenkins agent running the tests are cleared
+		jenkinsEnv := []string{"BUILD_NUMBER", "BUILD_TAG", "BUILD_URL", "BUILD_USER_ID", "GIT_COMMIT", "GIT_URL", "JENKINS_API_TOKEN",
+			"JENKINS_API_USER", "JENKINS_QUERY_API", "JENKINS_URL", "JOB_NAME", "NODE_NAME"}
+		var savedEnv map[string]string
+
+		BeforeEach(func() {
+			savedEnv = map[string]string{}
+			for _, name := range jenkinsEnv {
+				if value, ok := os.LookupEnv(name); ok {
+					savedEnv[name] = value
+				}
+				os.Unsetenv(name)
+			}
+		})
+
+		AfterEach(func() {
+			for _, name := range jenkinsEnv {
+				os.Unsetenv(name)
+			}
+			for name, value := range savedEnv {
+				os.Setenv(name, value)
+			}
+		})
+
+		It("should record a Jenkins build from its environment", func() {
+			envFile := filepath.Join(tempDir(), ".env")
+			Expect(os.WriteFile(envFile, []byte(`JENKINS_URL=https://jenkins.example.com/
+BUILD_URL=https://jenkins.example.com/job/demo-app/12/
+GIT_COMMIT=8f3e2c1
+GIT_URL=git@github.com:rode/demo-app.git
+`), 0644)).To(Succeed())
+
+			_, err := runAction(append([]string{"run",
+				"-ci-provider", "jenkins",
+				"-env-file", envFile,
+				"-artifact-id", "harbor.example.com/rode/app@sha256:123",
+			}, connectionArgs...)...)
+
+			Expect(err).NotTo(HaveOccurred())
+			requests := buildCollector.receivedCreates()
+			Expect(requests).To(HaveLen(1))
+			Expect(requests[0].CommitId).To(Equal("8f3e2c1"))
+			Expect(requests[0].Repository).To(Equal("https://github.com/rode/demo-app"))
+			Expect(requests[0].LogsUri).To(Equal("https://jenkins.example.com/job/demo-app/12/consoleText"))
+		})
+	})
+})
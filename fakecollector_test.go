@@ -0,0 +1,141 @@
+// Copyright 2021 The Rode Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"encoding/pem"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+
+	. "github.com/onsi/gomega"
+	collector "github.com/rode/collector-build/proto/v1alpha1"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// fakeCollector is an in-process build collector for end-to-end tests. It listens on localhost, records every request, and
+// fails the next calls with any errors that have been scripted with failNext.
+type fakeCollector struct {
+	collector.UnimplementedBuildCollectorServer
+	// id is the build occurrence id that's returned
+	id string
+	// token is required as a bearer token when it's set
+	token string
+
+	// host and caFile are set by start, caFile only when the server uses TLS
+	host   string
+	caFile string
+
+	mu             sync.Mutex
+	server         *grpc.Server
+	createRequests []*collector.CreateBuildRequest
+	updateRequests []*collector.UpdateBuildArtifactsRequest
+	errs           []error
+}
+
+// start serves the fake build collector until stop is called. When useTLS is set, it uses a self-signed certificate that
+// caFile trusts.
+func (f *fakeCollector) start(useTLS bool) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	Expect(err).NotTo(HaveOccurred())
+	f.host = listener.Addr().String()
+
+	var opts []grpc.ServerOption
+	if useTLS {
+		cert := selfSignedCertificate()
+		f.caFile = filepath.Join(tempDir(), "ca.pem")
+		Expect(os.WriteFile(f.caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Certificate[0]}), 0644)).To(Succeed())
+		opts = append(opts, grpc.Creds(credentials.NewServerTLSFromCert(&cert)))
+	}
+
+	f.server = grpc.NewServer(opts...)
+	collector.RegisterBuildCollectorServer(f.server, f)
+	go f.server.Serve(listener)
+}
+
+func (f *fakeCollector) stop() {
+	f.server.Stop()
+}
+
+// failNext makes the next calls return these errors, one per call, e.g. status.Error(codes.Unavailable, "...")
+func (f *fakeCollector) failNext(errs ...error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.errs = append(f.errs, errs...)
+}
+
+func (f *fakeCollector) receivedCreates() []*collector.CreateBuildRequest {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return append([]*collector.CreateBuildRequest(nil), f.createRequests...)
+}
+
+func (f *fakeCollector) receivedUpdates() []*collector.UpdateBuildArtifactsRequest {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return append([]*collector.UpdateBuildArtifactsRequest(nil), f.updateRequests...)
+}
+
+// call checks the token and returns the next scripted error, if there is one
+func (f *fakeCollector) call(ctx context.Context) error {
+	if f.token != "" {
+		md, _ := metadata.FromIncomingContext(ctx)
+		if authorization := md.Get("authorization"); len(authorization) == 0 || authorization[0] != "Bearer "+f.token {
+			return status.Error(codes.Unauthenticated, "invalid token")
+		}
+	}
+
+	if len(f.errs) == 0 {
+		return nil
+	}
+
+	err := f.errs[0]
+	f.errs = f.errs[1:]
+
+	return err
+}
+
+func (f *fakeCollector) CreateBuild(ctx context.Context, request *collector.CreateBuildRequest) (*collector.CreateBuildResponse, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if err := f.call(ctx); err != nil {
+		return nil, err
+	}
+	f.createRequests = append(f.createRequests, request)
+
+	return &collector.CreateBuildResponse{BuildOccurrenceId: f.id}, nil
+}
+
+func (f *fakeCollector) UpdateBuildArtifacts(ctx context.Context, request *collector.UpdateBuildArtifactsRequest) (*collector.UpdateBuildArtifactsResponse, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if err := f.call(ctx); err != nil {
+		return nil, err
+	}
+	f.updateRequests = append(f.updateRequests, request)
+
+	return &collector.UpdateBuildArtifactsResponse{BuildOccurrenceId: f.id}, nil
+}
@@ -0,0 +1,76 @@
+// Copyright 2021 The Rode Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("files", func() {
+	var dir string
+
+	BeforeEach(func() {
+		dir = tempDir()
+	})
+
+	Describe("writeFile", func() {
+		It("should create the parent directories", func() {
+			path := filepath.Join(dir, "out", "build.json")
+
+			Expect(writeFile(path, []byte("{}"))).To(Succeed())
+
+			Expect(os.ReadFile(path)).To(Equal([]byte("{}")))
+		})
+
+		It("should replace an existing file without leaving temporary files behind", func() {
+			path := filepath.Join(dir, "build.json")
+			Expect(os.WriteFile(path, []byte(`{"commitId": "foo"}`), 0600)).To(Succeed())
+
+			Expect(writeFile(path, []byte(`{"commitId": "bar"}`))).To(Succeed())
+
+			Expect(os.ReadFile(path)).To(Equal([]byte(`{"commitId": "bar"}`)))
+			info, err := os.Stat(path)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(info.Mode().Perm()).To(Equal(os.FileMode(0644)))
+			entries, err := os.ReadDir(dir)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(entries).To(HaveLen(1))
+		})
+
+		It("should leave the existing file alone when it can't write", func() {
+			path := filepath.Join(dir, "build.json", "nested")
+
+			Expect(writeFile(filepath.Join(dir, "build.json"), []byte("{}"))).To(Succeed())
+			Expect(writeFile(path, []byte("{}"))).NotTo(Succeed())
+
+			Expect(os.ReadFile(filepath.Join(dir, "build.json"))).To(Equal([]byte("{}")))
+		})
+	})
+
+	Describe("appendFile", func() {
+		It("should append to the file", func() {
+			path := filepath.Join(dir, "summary.md")
+
+			Expect(appendFile(path, []byte("# SBOMs\n"))).To(Succeed())
+			Expect(appendFile(path, []byte("- app.spdx.json\n"))).To(Succeed())
+
+			Expect(os.ReadFile(path)).To(Equal([]byte("# SBOMs\n- app.spdx.json\n")))
+		})
+	})
+})
@@ -0,0 +1,580 @@
+// Copyright 2021 The Rode Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/google/go-github/v35/github"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"golang.org/x/oauth2"
+)
+
+const (
+	githubWorkflowBuildTypeV02 = "https://github.com/Attestations/GitHubActionsWorkflow@v1"
+	githubWorkflowBuildTypeV1  = "https://slsa-framework.github.io/github-actions-buildtypes/workflow/v1"
+
+	githubHostedBuilderId = "https://github.com/Attestations/GitHubHostedActions@v1"
+	selfHostedBuilderId   = "https://github.com/Attestations/SelfHostedActions@v1"
+
+	// scopeJob records the job running the action, scopeWorkflow records every job in the workflow run
+	scopeJob      = "job"
+	scopeWorkflow = "workflow"
+
+	// commitSourceSha uses GITHUB_SHA, which is the merge commit for pull requests. commitSourceHead uses the head of the pull request.
+	commitSourceSha  = "sha"
+	commitSourceHead = "head"
+)
+
+type githubConfig struct {
+	Actor           string `env:"ACTOR,required"`
+	ApiUrl          string `env:"API_URL,default=https://api.github.com"`
+	CommitId        string `env:"SHA,required"`
+	EventName       string `env:"EVENT_NAME"`
+	EventPath       string `env:"EVENT_PATH"`
+	JobId           string `env:"JOB,required"`
+	Ref             string `env:"REF"`
+	RepoSlug        string `env:"REPOSITORY,required"`
+	RunAttempt      string `env:"RUN_ATTEMPT"`
+	RunId           int64  `env:"RUN_ID,required"`
+	RunNumber       string `env:"RUN_NUMBER"`
+	ServerUrl       string `env:"SERVER_URL,required"`
+	StepSummary     string `env:"STEP_SUMMARY"`
+	Token           string `env:"TOKEN,required"`
+	TriggeringActor string `env:"TRIGGERING_ACTOR"`
+	Workflow        string `env:"WORKFLOW"`
+	WorkflowRef     string `env:"WORKFLOW_REF"`
+}
+
+type runnerConfig struct {
+	Arch        string `env:"ARCH"`
+	Environment string `env:"ENVIRONMENT"`
+	Name        string `env:"NAME"`
+	OS          string `env:"OS"`
+}
+
+//go:generate counterfeiter -o mocks/actions_service.go . actionsService
+type actionsService interface {
+	ListWorkflowJobs(ctx context.Context, owner, repo string, runID int64, opts *github.ListWorkflowJobsOptions) (*github.Jobs, *github.Response, error)
+}
+
+// usersService looks up the actor, when the creator is their email or id
+//
+//go:generate counterfeiter -o mocks/users_service.go . usersService
+type usersService interface {
+	Get(ctx context.Context, user string) (*github.User, *github.Response, error)
+}
+
+// gitService looks up the commit, for its author, committer and signature verification
+//
+//go:generate counterfeiter -o mocks/git_service.go . gitService
+type gitService interface {
+	GetCommit(ctx context.Context, owner, repo, sha string) (*github.Commit, *github.Response, error)
+}
+
+// githubEvent is the subset of the webhook payload in GITHUB_EVENT_PATH that's used, pull_request is only set for pull request events
+type githubEvent struct {
+	PullRequest *github.PullRequest `json:"pull_request"`
+}
+
+type githubProvider struct {
+	actions       actionsService
+	buildSteps    []string
+	commitSource  string
+	config        *githubConfig
+	creatorSource string
+	git           gitService
+	// requireSignedCommit makes a commit that can't be fetched an error, rather than leaving it unverified
+	requireSignedCommit bool
+	runner              *runnerConfig
+	users               usersService
+	// scope is either scopeJob or scopeWorkflow, workflowJobs optionally limits the jobs in the workflow scope
+	scope        string
+	workflowJobs []string
+}
+
+func newGitHubClient(c *githubConfig, timeout time.Duration) *github.Client {
+	tokenSource := oauth2.StaticTokenSource(
+		&oauth2.Token{
+			AccessToken: c.Token,
+		},
+	)
+
+	client := oauth2.NewClient(context.Background(), tokenSource)
+	client.Timeout = timeout
+
+	return github.NewClient(client)
+}
+
+func (g *githubProvider) BuildMetadata(ctx context.Context) (*buildMetadata, error) {
+	owner, repo, err := getRepoAndOwnerFromSlug(g.config.RepoSlug)
+	if err != nil {
+		return nil, err
+	}
+
+	job, jobs, err := g.currentJob(ctx, owner, repo)
+	if err != nil {
+		return nil, err
+	}
+
+	event, err := g.readEvent()
+	if err != nil {
+		return nil, err
+	}
+
+	repoUri := fmt.Sprintf("%s/%s", g.config.ServerUrl, g.config.RepoSlug)
+	commitUri := fmt.Sprintf("%s/commit/%s", repoUri, g.config.CommitId)
+	metadata := &buildMetadata{
+		Actor:       g.config.Actor,
+		CommitId:    g.config.CommitId,
+		CommitUri:   commitUri,
+		Repository:  repoUri,
+		StepSummary: g.config.StepSummary,
+		Invocation:  g.invocation(repoUri, job),
+		Trigger:     g.trigger(),
+	}
+
+	if pullRequest := event.PullRequest; pullRequest != nil {
+		if g.commitSource == commitSourceHead {
+			metadata.CommitId = pullRequest.GetHead().GetSHA()
+			metadata.CommitUri = fmt.Sprintf("%s/commit/%s", repoUri, metadata.CommitId)
+		}
+
+		metadata.PullRequest = &buildPullRequest{
+			Number:  pullRequest.GetNumber(),
+			Url:     pullRequest.GetHTMLURL(),
+			HeadRef: pullRequest.GetHead().GetRef(),
+			HeadSha: pullRequest.GetHead().GetSHA(),
+			BaseRef: pullRequest.GetBase().GetRef(),
+		}
+
+		environment := metadata.Invocation.Environment
+		environment["github_sha"] = g.config.CommitId
+		environment["github_pull_request_number"] = fmt.Sprint(metadata.PullRequest.Number)
+		environment["github_pull_request_url"] = metadata.PullRequest.Url
+		environment["github_head_ref"] = metadata.PullRequest.HeadRef
+		environment["github_head_sha"] = metadata.PullRequest.HeadSha
+		environment["github_base_ref"] = metadata.PullRequest.BaseRef
+	}
+
+	// the commit is only needed to require its signature or to take the creator from it, otherwise it's left unverified when it can't be fetched
+	commit, _, err := g.git.GetCommit(ctx, owner, repo, metadata.CommitId)
+	switch {
+	case err != nil && (g.requireSignedCommit || g.creatorSource == creatorSourceAuthor || g.creatorSource == creatorSourceCommitter):
+		return nil, fmt.Errorf("error fetching commit %s: %s", metadata.CommitId, err)
+	case err != nil:
+		metadata.Warnings = append(metadata.Warnings, fmt.Sprintf("Unable to check the signature of commit %s: %s", metadata.CommitId, err))
+	default:
+		metadata.Verification = verification(commit)
+		metadata.Invocation.Environment["github_commit_verified"] = fmt.Sprint(metadata.Verification.Verified)
+		metadata.Invocation.Environment["github_commit_verification_reason"] = metadata.Verification.Reason
+		metadata.Invocation.Environment["github_commit_signer"] = metadata.Verification.Signer
+	}
+
+	metadata.Actor, err = g.creator(ctx, commit)
+	if err != nil {
+		return nil, err
+	}
+
+	if g.scope == scopeWorkflow {
+		workflowJobs, warnings, err := g.selectJobs(jobs, job)
+		if err != nil {
+			return nil, err
+		}
+		metadata.Warnings = append(metadata.Warnings, warnings...)
+
+		metadata.BuildStart, metadata.BuildEnd, err = workflowTiming(workflowJobs)
+		if err != nil {
+			return nil, err
+		}
+
+		var names []string
+		for _, j := range workflowJobs {
+			names = append(names, j.GetName())
+		}
+		metadata.Invocation.Environment["github_workflow_jobs"] = strings.Join(names, ",")
+		metadata.LogsUri = g.runLogsUri(owner, repo)
+		metadata.ProvenanceId = metadata.Invocation.Id
+
+		return metadata, nil
+	}
+
+	metadata.BuildStart, metadata.BuildEnd = job.GetStartedAt().Time, time.Now()
+	if len(g.buildSteps) > 0 {
+		metadata.BuildStart, metadata.BuildEnd, err = stepTiming(job, g.buildSteps)
+		if err != nil {
+			return nil, err
+		}
+	}
+	metadata.LogsUri = fmt.Sprintf("%s/checks/%d/logs", commitUri, job.GetID())
+	metadata.ProvenanceId = job.GetHTMLURL()
+
+	return metadata, nil
+}
+
+// creator looks up the identity of the creator from the users or git API, depending on the creator source
+func (g *githubProvider) creator(ctx context.Context, commit *github.Commit) (string, error) {
+	switch g.creatorSource {
+	case creatorSourceEmail, creatorSourceId:
+		user, _, err := g.users.Get(ctx, g.config.Actor)
+		if err != nil {
+			return "", fmt.Errorf("error fetching user %s: %s", g.config.Actor, err)
+		}
+
+		if g.creatorSource == creatorSourceId {
+			return fmt.Sprint(user.GetID()), nil
+		}
+
+		if user.GetEmail() != "" {
+			return user.GetEmail(), nil
+		}
+
+		// users without a public email can still be identified by the noreply address that GitHub attributes to them
+		host := "github.com"
+		if serverUrl, err := url.Parse(g.config.ServerUrl); err == nil && serverUrl.Host != "" {
+			host = serverUrl.Host
+		}
+
+		return fmt.Sprintf("%d+%s@users.noreply.%s", user.GetID(), user.GetLogin(), host), nil
+	case creatorSourceAuthor, creatorSourceCommitter:
+		identity := commit.GetAuthor()
+		if g.creatorSource == creatorSourceCommitter {
+			identity = commit.GetCommitter()
+		}
+
+		if identity.GetEmail() == "" {
+			return "", fmt.Errorf("commit %s has no %s email", commit.GetSHA(), g.creatorSource)
+		}
+
+		return identity.GetEmail(), nil
+	}
+
+	return g.config.Actor, nil
+}
+
+// verification summarizes the signature verification of the commit. GitHub only verifies a signature made by the committer, so they're the signer.
+func verification(commit *github.Commit) *commitVerification {
+	result := &commitVerification{
+		Verified: commit.GetVerification().GetVerified(),
+		Reason:   commit.GetVerification().GetReason(),
+	}
+	if result.Verified {
+		result.Signer = commit.GetCommitter().GetEmail()
+	}
+
+	return result
+}
+
+// readEvent parses the payload of the event that triggered the workflow
+func (g *githubProvider) readEvent() (*githubEvent, error) {
+	event := &githubEvent{}
+	if g.config.EventPath == "" {
+		return event, nil
+	}
+
+	contents, err := os.ReadFile(g.config.EventPath)
+	if err != nil {
+		return nil, fmt.Errorf("error reading event: %s", err)
+	}
+
+	if err := json.Unmarshal(contents, event); err != nil {
+		return nil, fmt.Errorf("error parsing event %s: %s", g.config.EventPath, err)
+	}
+
+	return event, nil
+}
+
+// currentJob finds the job running the action among the jobs in the workflow run, which are also returned
+func (g *githubProvider) currentJob(ctx context.Context, owner, repo string) (_ *github.WorkflowJob, _ []*github.WorkflowJob, err error) {
+	ctx, span := tracer().Start(ctx, "look up GitHub job", trace.WithAttributes(
+		attribute.String("github.repository", g.config.RepoSlug),
+		attribute.Int64("github.run_id", g.config.RunId),
+		attribute.String("github.job", g.config.JobId),
+	))
+	defer func() { endSpan(span, err) }()
+
+	jobs, err := g.listJobs(ctx, owner, repo)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error listing jobs: %s", err)
+	}
+	span.SetAttributes(attribute.Int("github.jobs", len(jobs)))
+
+	for _, job := range jobs {
+		if job.GetName() == g.config.JobId {
+			return job, jobs, nil
+		}
+	}
+
+	return nil, nil, fmt.Errorf("unable to find job with id %s", g.config.JobId)
+}
+
+// listJobs pages through every job in the workflow run
+func (g *githubProvider) listJobs(ctx context.Context, owner, repo string) ([]*github.WorkflowJob, error) {
+	var jobs []*github.WorkflowJob
+	options := &github.ListWorkflowJobsOptions{ListOptions: github.ListOptions{PerPage: 100}}
+	for {
+		page, response, err := g.actions.ListWorkflowJobs(ctx, owner, repo, g.config.RunId, options)
+		if err != nil {
+			return nil, err
+		}
+		jobs = append(jobs, page.Jobs...)
+
+		if response == nil || response.NextPage == 0 {
+			return jobs, nil
+		}
+		options.Page = response.NextPage
+	}
+}
+
+// selectJobs returns the jobs named in workflowJobs, or every other job in the run that has completed. Jobs that are still queued
+// or running, like ones that don't run until after this one, are skipped with a warning, since there's no timing for them yet.
+func (g *githubProvider) selectJobs(jobs []*github.WorkflowJob, current *github.WorkflowJob) ([]*github.WorkflowJob, []string, error) {
+	if len(g.workflowJobs) == 0 {
+		var selected []*github.WorkflowJob
+		var warnings []string
+		for _, j := range jobs {
+			switch {
+			case j.GetID() == current.GetID():
+			case j.CompletedAt == nil:
+				warnings = append(warnings, fmt.Sprintf("Job %q has not completed, so it isn't recorded as part of the build. Add it to the needs of this job to include it", j.GetName()))
+			default:
+				selected = append(selected, j)
+			}
+		}
+
+		if len(selected) == 0 && len(warnings) > 0 {
+			return nil, nil, fmt.Errorf("none of the other jobs in the workflow run have completed, add them to the needs of the job running this action")
+		}
+
+		if len(selected) == 0 {
+			return nil, nil, fmt.Errorf("there are no other jobs in the workflow run, use the job scope instead")
+		}
+
+		return selected, warnings, nil
+	}
+
+	byName := map[string]*github.WorkflowJob{}
+	var available []string
+	for _, j := range jobs {
+		byName[j.GetName()] = j
+		available = append(available, fmt.Sprintf("%q", j.GetName()))
+	}
+
+	var selected []*github.WorkflowJob
+	for _, name := range g.workflowJobs {
+		j, ok := byName[name]
+		if !ok {
+			return nil, nil, fmt.Errorf("unable to find job %q in the workflow run, the jobs are %s", name, strings.Join(available, ", "))
+		}
+		selected = append(selected, j)
+	}
+
+	return selected, nil, nil
+}
+
+// runLogsUri is the API url to download the logs of every job in the run as a zip archive
+func (g *githubProvider) runLogsUri(owner, repo string) string {
+	runUri := fmt.Sprintf("%s/repos/%s/%s/actions/runs/%d", strings.TrimSuffix(g.config.ApiUrl, "/"), owner, repo, g.config.RunId)
+	if g.config.RunAttempt != "" {
+		runUri = fmt.Sprintf("%s/attempts/%s", runUri, g.config.RunAttempt)
+	}
+
+	return runUri + "/logs"
+}
+
+func (g *githubProvider) invocation(repoUri string, job *github.WorkflowJob) *buildInvocation {
+	invocationId := fmt.Sprintf("%s/actions/runs/%d", repoUri, g.config.RunId)
+	if g.config.RunAttempt != "" {
+		invocationId = fmt.Sprintf("%s/attempts/%s", invocationId, g.config.RunAttempt)
+	}
+
+	builderId := githubHostedBuilderId
+	if g.runner.Environment == "self-hosted" {
+		builderId = selfHostedBuilderId
+	}
+
+	// older runners don't set GITHUB_WORKFLOW_REF, so fall back to the name of the workflow
+	entryPoint, entryPointRef := g.config.Workflow, ""
+	if path, ref, ok := parseWorkflowRef(g.config.RepoSlug, g.config.WorkflowRef); ok {
+		entryPoint, entryPointRef = path, ref
+	}
+
+	trigger := g.trigger()
+
+	return &buildInvocation{
+		Id:            invocationId,
+		Provider:      providerGitHub,
+		BuilderId:     builderId,
+		BuildType:     githubWorkflowBuildTypeV1,
+		BuildTypeV02:  githubWorkflowBuildTypeV02,
+		EntryPoint:    entryPoint,
+		EntryPointRef: entryPointRef,
+		Environment: map[string]interface{}{
+			"github_run_id":           fmt.Sprint(g.config.RunId),
+			"github_run_number":       g.config.RunNumber,
+			"github_run_attempt":      g.config.RunAttempt,
+			"github_job":              g.config.JobId,
+			"github_job_id":           fmt.Sprint(job.GetID()),
+			"github_actor":            g.config.Actor,
+			"github_event_name":       trigger.Event,
+			"github_ref":              trigger.Ref,
+			"github_triggering_actor": trigger.TriggeringActor,
+			"github_workflow":         trigger.Workflow,
+			"github_workflow_ref":     trigger.WorkflowRef,
+			"runner_name":             g.runner.Name,
+			"runner_os":               g.runner.OS,
+			"runner_arch":             g.runner.Arch,
+			"runner_environment":      g.runner.Environment,
+		},
+	}
+}
+
+func (g *githubProvider) trigger() *buildTrigger {
+	triggeringActor := g.config.TriggeringActor
+	if triggeringActor == "" {
+		triggeringActor = g.config.Actor
+	}
+
+	return &buildTrigger{
+		Event:           g.config.EventName,
+		Ref:             g.config.Ref,
+		TriggeringActor: triggeringActor,
+		Workflow:        g.config.Workflow,
+		WorkflowRef:     g.config.WorkflowRef,
+	}
+}
+
+// parseWorkflowRef splits GITHUB_WORKFLOW_REF into the path of the workflow file in the repository, and the ref it was read from
+func parseWorkflowRef(slug, workflowRef string) (string, string, bool) {
+	i := strings.LastIndex(workflowRef, "@")
+	if i == -1 {
+		return "", "", false
+	}
+	path, ref := workflowRef[:i], workflowRef[i+1:]
+
+	// a workflow file from another repository keeps its full path
+	if strings.HasPrefix(path, slug+"/") {
+		path = strings.TrimPrefix(path, slug+"/")
+	}
+
+	return path, ref, path != "" && ref != ""
+}
+
+func (g *githubProvider) metricLabels() metricLabels {
+	return metricLabels{
+		Repo:     fmt.Sprintf("%s/%s", g.config.ServerUrl, g.config.RepoSlug),
+		Workflow: g.config.Workflow,
+		Job:      g.config.JobId,
+	}
+}
+
+func (g *githubProvider) validate() configErrors {
+	var problems configErrors
+	if _, _, err := getRepoAndOwnerFromSlug(g.config.RepoSlug); err != nil {
+		problems = append(problems, fmt.Sprintf("GITHUB_REPOSITORY: %s", err))
+	}
+
+	problems = append(problems, validateUrl("GITHUB_API_URL", g.config.ApiUrl)...)
+	problems = append(problems, validateUrl("GITHUB_SERVER_URL", g.config.ServerUrl)...)
+
+	switch g.commitSource {
+	case "", commitSourceSha, commitSourceHead:
+	default:
+		problems = append(problems, fmt.Sprintf("COMMIT_SOURCE: %q is not supported, expected %s or %s", g.commitSource, commitSourceSha, commitSourceHead))
+	}
+
+	switch g.scope {
+	case "", scopeJob:
+		if len(g.workflowJobs) > 0 {
+			problems = append(problems, "WORKFLOW_JOBS: requires SCOPE to be workflow")
+		}
+	case scopeWorkflow:
+		if len(g.buildSteps) > 0 {
+			problems = append(problems, "BUILD_STEPS: can only be used with the job scope, use WORKFLOW_JOBS to select jobs instead")
+		}
+	default:
+		problems = append(problems, fmt.Sprintf("SCOPE: %q is not supported, expected %s or %s", g.scope, scopeJob, scopeWorkflow))
+	}
+
+	return problems
+}
+
+// workflowTiming returns when the first of the jobs started, and when the last of them completed
+func workflowTiming(jobs []*github.WorkflowJob) (time.Time, time.Time, error) {
+	var start, end time.Time
+	for _, j := range jobs {
+		if j.StartedAt == nil || j.CompletedAt == nil {
+			return time.Time{}, time.Time{}, fmt.Errorf("job %q has not completed, add it to the needs of the job running this action", j.GetName())
+		}
+
+		if start.IsZero() || j.StartedAt.Before(start) {
+			start = j.StartedAt.Time
+		}
+
+		if j.CompletedAt.After(end) {
+			end = j.CompletedAt.Time
+		}
+	}
+
+	return start, end, nil
+}
+
+// stepTiming returns when the first of the named steps started, and when the last of them completed
+func stepTiming(job *github.WorkflowJob, names []string) (time.Time, time.Time, error) {
+	steps := map[string]*github.TaskStep{}
+	var available []string
+	for _, step := range job.Steps {
+		steps[step.GetName()] = step
+		available = append(available, fmt.Sprintf("%q", step.GetName()))
+	}
+
+	var start, end time.Time
+	for _, name := range names {
+		step, ok := steps[name]
+		if !ok {
+			return time.Time{}, time.Time{}, fmt.Errorf("unable to find step %q in job %s, the steps so far are %s", name, job.GetName(), strings.Join(available, ", "))
+		}
+
+		if step.StartedAt == nil || step.CompletedAt == nil {
+			return time.Time{}, time.Time{}, fmt.Errorf("step %q has not completed, the build steps must run before this one", name)
+		}
+
+		if start.IsZero() || step.StartedAt.Before(start) {
+			start = step.StartedAt.Time
+		}
+
+		if step.CompletedAt.After(end) {
+			end = step.CompletedAt.Time
+		}
+	}
+
+	return start, end, nil
+}
+
+func getRepoAndOwnerFromSlug(slug string) (string, string, error) {
+	parts := strings.Split(slug, "/")
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return "", "", fmt.Errorf("%q must be in the form owner/repo", slug)
+	}
+
+	return parts[0], parts[1], nil
+}
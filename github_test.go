@@ -0,0 +1,37 @@
+// Copyright 2021 The Rode Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("github", func() {
+	DescribeTable("parseWorkflowRef",
+		func(workflowRef, expectedPath, expectedRef string, expectedOk bool) {
+			path, ref, ok := parseWorkflowRef("rode/demo-app", workflowRef)
+
+			Expect(ok).To(Equal(expectedOk))
+			Expect(path).To(Equal(expectedPath))
+			Expect(ref).To(Equal(expectedRef))
+		},
+		Entry("workflow in the repository", "rode/demo-app/.github/workflows/build.yml@refs/heads/main", ".github/workflows/build.yml", "refs/heads/main", true),
+		Entry("reusable workflow in another repository", "rode/workflows/.github/workflows/build.yml@v1", "rode/workflows/.github/workflows/build.yml", "v1", true),
+		Entry("without a ref", "rode/demo-app/.github/workflows/build.yml", "", "", false),
+		Entry("unset", "", "", "", false),
+	)
+})
@@ -0,0 +1,167 @@
+// Copyright 2021 The Rode Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const gitlabPipelineBuildType = "https://docs.gitlab.com/ee/ci/yaml/"
+
+// gitlabConfig is populated from the predefined variables in GitLab CI: https://docs.gitlab.com/ee/ci/variables/predefined_variables.html
+type gitlabConfig struct {
+	ApiUrl            string `env:"CI_API_V4_URL,required"`
+	CommitSha         string `env:"CI_COMMIT_SHA,required"`
+	ConfigPath        string `env:"CI_CONFIG_PATH"`
+	JobId             int64  `env:"CI_JOB_ID,required"`
+	JobName           string `env:"CI_JOB_NAME"`
+	JobStartedAt      string `env:"CI_JOB_STARTED_AT"`
+	JobToken          string `env:"CI_JOB_TOKEN,required"`
+	JobUrl            string `env:"CI_JOB_URL,required"`
+	PipelineId        string `env:"CI_PIPELINE_ID"`
+	PipelineUrl       string `env:"CI_PIPELINE_URL"`
+	ProjectUrl        string `env:"CI_PROJECT_URL,required"`
+	RunnerDescription string `env:"CI_RUNNER_DESCRIPTION"`
+	RunnerId          string `env:"CI_RUNNER_ID"`
+	ServerUrl         string `env:"CI_SERVER_URL"`
+	UserLogin         string `env:"GITLAB_USER_LOGIN"`
+}
+
+// gitlabJob is the subset of the jobs API response that's used: https://docs.gitlab.com/ee/api/jobs.html#get-job-tokens-job
+type gitlabJob struct {
+	Id        int64      `json:"id"`
+	StartedAt *time.Time `json:"started_at"`
+	WebUrl    string     `json:"web_url"`
+	User      *struct {
+		Username string `json:"username"`
+	} `json:"user"`
+}
+
+type gitlabProvider struct {
+	client *http.Client
+	config *gitlabConfig
+}
+
+func (g *gitlabProvider) BuildMetadata(ctx context.Context) (*buildMetadata, error) {
+	job, err := g.getJob(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching job: %s", err)
+	}
+
+	jobUrl := g.config.JobUrl
+	if job.WebUrl != "" {
+		jobUrl = job.WebUrl
+	}
+
+	actor := g.config.UserLogin
+	if job.User != nil && job.User.Username != "" {
+		actor = job.User.Username
+	}
+
+	var buildStart time.Time
+	if job.StartedAt != nil {
+		buildStart = *job.StartedAt
+	} else if g.config.JobStartedAt != "" {
+		buildStart, err = time.Parse(time.RFC3339, g.config.JobStartedAt)
+		if err != nil {
+			return nil, fmt.Errorf("invalid job start time %q: %s", g.config.JobStartedAt, err)
+		}
+	}
+
+	repoUri := strings.TrimSuffix(g.config.ProjectUrl, "/")
+
+	return &buildMetadata{
+		Actor:        actor,
+		BuildEnd:     time.Now(),
+		BuildStart:   buildStart,
+		CommitId:     g.config.CommitSha,
+		CommitUri:    fmt.Sprintf("%s/-/commit/%s", repoUri, g.config.CommitSha),
+		LogsUri:      jobUrl + "/raw",
+		ProvenanceId: jobUrl,
+		Repository:   repoUri,
+		Invocation: &buildInvocation{
+			Id:           jobUrl,
+			Provider:     providerGitLab,
+			BuilderId:    g.builderId(),
+			BuildType:    gitlabPipelineBuildType,
+			BuildTypeV02: gitlabPipelineBuildType,
+			EntryPoint:   g.config.ConfigPath,
+			Environment: map[string]interface{}{
+				"gitlab_job_id":             fmt.Sprint(g.config.JobId),
+				"gitlab_job_name":           g.config.JobName,
+				"gitlab_pipeline_id":        g.config.PipelineId,
+				"gitlab_pipeline_url":       g.config.PipelineUrl,
+				"gitlab_user_login":         actor,
+				"gitlab_runner_id":          g.config.RunnerId,
+				"gitlab_runner_description": g.config.RunnerDescription,
+			},
+		},
+	}, nil
+}
+
+// getJob uses the job token to look up the current job
+func (g *gitlabProvider) getJob(ctx context.Context) (*gitlabJob, error) {
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, strings.TrimSuffix(g.config.ApiUrl, "/")+"/job", nil)
+	if err != nil {
+		return nil, err
+	}
+	request.Header.Set("JOB-TOKEN", g.config.JobToken)
+
+	response, err := g.client.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %d from the jobs API", response.StatusCode)
+	}
+
+	job := &gitlabJob{}
+	if err := json.NewDecoder(response.Body).Decode(job); err != nil {
+		return nil, fmt.Errorf("error decoding job: %s", err)
+	}
+
+	return job, nil
+}
+
+// metricLabels has no workflow, GitLab pipelines aren't named
+func (g *gitlabProvider) metricLabels() metricLabels {
+	return metricLabels{Repo: g.config.ProjectUrl, Job: g.config.JobName}
+}
+
+func (g *gitlabProvider) validate() configErrors {
+	var problems configErrors
+	problems = append(problems, validateUrl("CI_API_V4_URL", g.config.ApiUrl)...)
+	problems = append(problems, validateUrl("CI_JOB_URL", g.config.JobUrl)...)
+	problems = append(problems, validateUrl("CI_PROJECT_URL", g.config.ProjectUrl)...)
+	problems = append(problems, validateUrl("CI_SERVER_URL", g.config.ServerUrl)...)
+
+	return append(problems, validateTime("CI_JOB_STARTED_AT", g.config.JobStartedAt)...)
+}
+
+func (g *gitlabProvider) builderId() string {
+	serverUrl := strings.TrimSuffix(g.config.ServerUrl, "/")
+	if g.config.RunnerId == "" {
+		return serverUrl + "/-/runners"
+	}
+
+	return fmt.Sprintf("%s/-/runners/%s", serverUrl, g.config.RunnerId)
+}
@@ -0,0 +1,142 @@
+// Copyright 2021 The Rode Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("gitlabProvider", func() {
+	var (
+		ctx          context.Context
+		server       *httptest.Server
+		jobToken     string
+		jobStartedAt time.Time
+		response     string
+		statusCode   int
+		provider     *gitlabProvider
+
+		actualMetadata *buildMetadata
+		actualError    error
+	)
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		jobToken = fake.LetterN(20)
+		jobStartedAt = time.Date(2021, 6, 1, 12, 30, 0, 0, time.UTC)
+		statusCode = http.StatusOK
+		response = `{
+  "id": 42,
+  "started_at": "2021-06-01T12:30:00.000Z",
+  "web_url": "https://gitlab.example.com/rode/demo-app/-/jobs/42",
+  "user": {"username": "jdoe"}
+}`
+
+		server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path != "/api/v4/job" || r.Header.Get("JOB-TOKEN") != jobToken {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+
+			w.WriteHeader(statusCode)
+			w.Write([]byte(response))
+		}))
+
+		provider = &gitlabProvider{
+			client: server.Client(),
+			config: &gitlabConfig{
+				ApiUrl:      server.URL + "/api/v4",
+				CommitSha:   "foobar",
+				ConfigPath:  ".gitlab-ci.yml",
+				JobId:       42,
+				JobToken:    jobToken,
+				JobUrl:      "https://gitlab.example.com/rode/demo-app/-/jobs/42",
+				PipelineId:  "7",
+				ProjectUrl:  "https://gitlab.example.com/rode/demo-app",
+				RunnerId:    "3",
+				ServerUrl:   "https://gitlab.example.com",
+				UserLogin:   "ci-user",
+				JobName:     "build",
+				PipelineUrl: "https://gitlab.example.com/rode/demo-app/-/pipelines/7",
+			},
+		}
+	})
+
+	AfterEach(func() {
+		server.Close()
+	})
+
+	JustBeforeEach(func() {
+		actualMetadata, actualError = provider.BuildMetadata(ctx)
+	})
+
+	It("should describe the build using the project and job", func() {
+		Expect(actualError).NotTo(HaveOccurred())
+
+		Expect(actualMetadata.Actor).To(Equal("jdoe"))
+		Expect(actualMetadata.BuildStart).To(BeTemporally("==", jobStartedAt))
+		Expect(actualMetadata.CommitId).To(Equal("foobar"))
+		Expect(actualMetadata.CommitUri).To(Equal("https://gitlab.example.com/rode/demo-app/-/commit/foobar"))
+		Expect(actualMetadata.LogsUri).To(Equal("https://gitlab.example.com/rode/demo-app/-/jobs/42/raw"))
+		Expect(actualMetadata.ProvenanceId).To(Equal("https://gitlab.example.com/rode/demo-app/-/jobs/42"))
+		Expect(actualMetadata.Repository).To(Equal("https://gitlab.example.com/rode/demo-app"))
+	})
+
+	It("should describe the pipeline for the provenance", func() {
+		Expect(actualMetadata.Invocation.BuilderId).To(Equal("https://gitlab.example.com/-/runners/3"))
+		Expect(actualMetadata.Invocation.EntryPoint).To(Equal(".gitlab-ci.yml"))
+		Expect(actualMetadata.Invocation.Environment).To(HaveKeyWithValue("gitlab_pipeline_id", "7"))
+	})
+
+	When("the job hasn't recorded a start time or user", func() {
+		BeforeEach(func() {
+			response = `{"id": 42}`
+			provider.config.JobStartedAt = "2021-06-01T12:00:00Z"
+		})
+
+		It("should fall back to the predefined variables", func() {
+			Expect(actualError).NotTo(HaveOccurred())
+			Expect(actualMetadata.Actor).To(Equal("ci-user"))
+			Expect(actualMetadata.BuildStart).To(BeTemporally("==", jobStartedAt.Add(-30*time.Minute)))
+			Expect(actualMetadata.ProvenanceId).To(Equal(provider.config.JobUrl))
+		})
+	})
+
+	When("the job token is rejected", func() {
+		BeforeEach(func() {
+			provider.config.JobToken = fake.LetterN(10)
+		})
+
+		It("should return an error", func() {
+			Expect(actualError).To(MatchError(ContainSubstring("error fetching job: unexpected status 401")))
+		})
+	})
+
+	When("the response is not valid JSON", func() {
+		BeforeEach(func() {
+			response = "<html>"
+		})
+
+		It("should return an error", func() {
+			Expect(actualError).To(MatchError(ContainSubstring("error decoding job")))
+		})
+	})
+})
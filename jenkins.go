@@ -0,0 +1,230 @@
+// Copyright 2021 The Rode Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+)
+
+const jenkinsPipelineBuildType = "https://www.jenkins.io/doc/book/pipeline/"
+
+// scpLikeGitUrl matches the short form of SSH remotes, e.g. git@github.com:rode/demo-app.git
+var scpLikeGitUrl = regexp.MustCompile(`^(?:[^@/]+@)?([^:/]+):(.+)$`)
+
+// jenkinsConfig is populated from the variables that Jenkins sets for every build, and those set by the git plugin:
+// https://www.jenkins.io/doc/book/pipeline/jenkins-file/#using-environment-variables. BUILD_START is the override that the action
+// applies for every CI system, it's only read here to tell whether the start of the build is known.
+type jenkinsConfig struct {
+	ApiToken    string `env:"JENKINS_API_TOKEN"`
+	ApiUser     string `env:"JENKINS_API_USER"`
+	BuildNumber string `env:"BUILD_NUMBER"`
+	BuildStart  string `env:"BUILD_START"`
+	BuildTag    string `env:"BUILD_TAG"`
+	BuildUrl    string `env:"BUILD_URL,required"`
+	BuildUser   string `env:"BUILD_USER_ID"`
+	GitCommit   string `env:"GIT_COMMIT,required"`
+	GitUrl      string `env:"GIT_URL,required"`
+	JenkinsUrl  string `env:"JENKINS_URL"`
+	JobName     string `env:"JOB_NAME"`
+	NodeName    string `env:"NODE_NAME"`
+	QueryApi    bool   `env:"JENKINS_QUERY_API"`
+}
+
+// jenkinsBuild is the subset of the build's remote API response that's used: https://www.jenkins.io/doc/book/using/remote-access-api/
+type jenkinsBuild struct {
+	// Timestamp is when the build started, in milliseconds since the epoch
+	Timestamp int64 `json:"timestamp"`
+	Actions   []struct {
+		Causes []struct {
+			UserId string `json:"userId"`
+		} `json:"causes"`
+	} `json:"actions"`
+}
+
+type jenkinsProvider struct {
+	client *http.Client
+	config *jenkinsConfig
+}
+
+func (j *jenkinsProvider) BuildMetadata(ctx context.Context) (*buildMetadata, error) {
+	repoUri, err := normalizeGitUrl(j.config.GitUrl)
+	if err != nil {
+		return nil, fmt.Errorf("invalid git url %q: %s", j.config.GitUrl, err)
+	}
+
+	buildUrl := strings.TrimSuffix(j.config.BuildUrl, "/")
+	actor := j.config.BuildUser
+
+	// the environment doesn't say when the build started, so without the API the build is recorded as starting when it ended
+	buildEnd := time.Now()
+	buildStart := buildEnd
+	var warnings []string
+	if j.config.QueryApi {
+		build, err := j.getBuild(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("error fetching build: %s", err)
+		}
+
+		buildStart = time.Unix(0, build.Timestamp*int64(time.Millisecond)).UTC()
+		if actor == "" {
+			actor = build.userId()
+		}
+	} else if j.config.BuildStart == "" {
+		warnings = append(warnings, "The start of the build isn't known, so it's recorded as the end. Set JENKINS_QUERY_API=true to look it up, or set BUILD_START")
+	}
+
+	return &buildMetadata{
+		Actor:        actor,
+		BuildEnd:     buildEnd,
+		BuildStart:   buildStart,
+		CommitId:     j.config.GitCommit,
+		CommitUri:    fmt.Sprintf("%s/commit/%s", repoUri, j.config.GitCommit),
+		LogsUri:      buildUrl + "/consoleText",
+		ProvenanceId: buildUrl,
+		Repository:   repoUri,
+		Invocation: &buildInvocation{
+			Id:           buildUrl,
+			Provider:     providerJenkins,
+			BuilderId:    strings.TrimSuffix(j.config.JenkinsUrl, "/"),
+			BuildType:    jenkinsPipelineBuildType,
+			BuildTypeV02: jenkinsPipelineBuildType,
+			EntryPoint:   j.config.JobName,
+			Environment: map[string]interface{}{
+				"jenkins_build_number": j.config.BuildNumber,
+				"jenkins_build_tag":    j.config.BuildTag,
+				"jenkins_job_name":     j.config.JobName,
+				"jenkins_node_name":    j.config.NodeName,
+			},
+		},
+		Warnings: warnings,
+	}, nil
+}
+
+// metricLabels uses the Jenkins job as the job, which is the whole pipeline, and the same repository url as the build occurrence
+func (j *jenkinsProvider) metricLabels() metricLabels {
+	repoUri, err := normalizeGitUrl(j.config.GitUrl)
+	if err != nil {
+		repoUri = j.config.GitUrl
+	}
+
+	return metricLabels{Repo: repoUri, Job: j.config.JobName}
+}
+
+func (j *jenkinsProvider) validate() configErrors {
+	var problems configErrors
+	problems = append(problems, validateUrl("BUILD_URL", j.config.BuildUrl)...)
+	problems = append(problems, validateUrl("JENKINS_URL", j.config.JenkinsUrl)...)
+
+	if _, err := normalizeGitUrl(j.config.GitUrl); err != nil {
+		problems = append(problems, fmt.Sprintf("GIT_URL: %q is not a valid git url: %s", j.config.GitUrl, err))
+	}
+
+	if j.config.ApiToken != "" && j.config.ApiUser == "" {
+		problems = append(problems, "JENKINS_API_TOKEN: requires JENKINS_API_USER to be set")
+	}
+
+	return problems
+}
+
+func (j *jenkinsProvider) getBuild(ctx context.Context) (*jenkinsBuild, error) {
+	apiUrl := strings.TrimSuffix(j.config.BuildUrl, "/") + "/api/json?tree=timestamp,actions[causes[userId]]"
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, apiUrl, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	if j.config.ApiUser != "" {
+		request.SetBasicAuth(j.config.ApiUser, j.config.ApiToken)
+	}
+
+	response, err := j.client.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %d from the Jenkins API", response.StatusCode)
+	}
+
+	build := &jenkinsBuild{}
+	if err := json.NewDecoder(response.Body).Decode(build); err != nil {
+		return nil, fmt.Errorf("error decoding build: %s", err)
+	}
+
+	return build, nil
+}
+
+// userId returns the user that started the build, if it was started manually
+func (b *jenkinsBuild) userId() string {
+	for _, action := range b.Actions {
+		for _, cause := range action.Causes {
+			if cause.UserId != "" {
+				return cause.UserId
+			}
+		}
+	}
+
+	return ""
+}
+
+// normalizeGitUrl turns a clone url, e.g. git@github.com:rode/demo-app.git, into the https url of the repository
+func normalizeGitUrl(gitUrl string) (string, error) {
+	if !strings.Contains(gitUrl, "://") {
+		match := scpLikeGitUrl.FindStringSubmatch(gitUrl)
+		if match == nil {
+			return "", fmt.Errorf("unrecognized git url")
+		}
+
+		gitUrl = fmt.Sprintf("ssh://%s/%s", match[1], strings.TrimPrefix(match[2], "/"))
+	}
+
+	u, err := url.Parse(gitUrl)
+	if err != nil {
+		return "", err
+	}
+
+	switch u.Scheme {
+	case "ssh", "git", "git+ssh", "https", "http":
+	default:
+		return "", fmt.Errorf("unsupported scheme %q", u.Scheme)
+	}
+
+	if u.Hostname() == "" {
+		return "", fmt.Errorf("missing host")
+	}
+
+	host := u.Hostname()
+	// the SSH port isn't where the web interface is served, but an explicit https port is
+	if u.Scheme == "https" || u.Scheme == "http" {
+		host = u.Host
+	}
+
+	scheme := "https"
+	if u.Scheme == "http" {
+		scheme = "http"
+	}
+
+	path := strings.TrimSuffix(strings.TrimSuffix(u.Path, "/"), ".git")
+
+	return fmt.Sprintf("%s://%s%s", scheme, host, path), nil
+}
@@ -16,43 +16,106 @@ package main
 
 import (
 	"context"
-	"crypto/tls"
+	"errors"
+	"flag"
 	"fmt"
-	"log"
+	"io"
 	"os"
+	"os/signal"
+	"strings"
+	"syscall"
 	"time"
 
-	"github.com/google/go-github/v35/github"
 	collector "github.com/rode/collector-build/proto/v1alpha1"
-	"github.com/sethvargo/go-envconfig"
+	"go.opentelemetry.io/otel/attribute"
+	semconv "go.opentelemetry.io/otel/semconv/v1.4.0"
+	"go.opentelemetry.io/otel/trace"
 	"go.uber.org/zap"
-	"golang.org/x/oauth2"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/credentials"
 )
 
 type buildCollectorConfig struct {
-	Host     string `env:"HOST,required"`
-	Insecure bool   `env:"INSECURE"`
+	CaFile   string `env:"CA_FILE" usage:"A PEM file of CA certificates to trust for the build collector, as well as the system's"`
+	Host     string `env:"HOST" usage:"The build collector host and port. Required unless BUILD_COLLECTORS is set"`
+	Insecure bool   `env:"INSECURE" usage:"Connect to the build collector without TLS"`
 }
 
-type githubConfig struct {
-	Actor     string `env:"ACTOR,required"`
-	CommitId  string `env:"SHA,required"`
-	JobId     string `env:"JOB,required"`
-	RepoSlug  string `env:"REPOSITORY,required"`
-	RunId     int64  `env:"RUN_ID,required"`
-	ServerUrl string `env:"SERVER_URL,required"`
-	Token     string `env:"TOKEN,required"`
+type timeoutConfig struct {
+	Dial  time.Duration `env:"DIAL,default=5s" usage:"How long to wait to connect to the build collector, 0 to wait indefinitely"`
+	Rpc   time.Duration `env:"RPC,default=30s" usage:"How long to wait for each request to the build collector, the CI system or the image registry"`
+	Total time.Duration `env:"TOTAL,default=5m" usage:"How long the action may take altogether"`
+}
+
+type metricsConfig struct {
+	PushgatewayUrl string `env:"PUSHGATEWAY_URL" usage:"A Prometheus Pushgateway to push metrics about the build occurrence to when the action exits"`
+}
+
+type tracingConfig struct {
+	Endpoint string `env:"ENDPOINT" usage:"An OTLP/HTTP endpoint to send traces to, e.g. http://otel-collector:4318. Tracing is off when unset"`
+}
+
+type policyConfig struct {
+	AccessToken  string `env:"ACCESS_TOKEN" usage:"An access token for the Rode API, defaults to ACCESS_TOKEN"`
+	Failure      string `env:"FAILURE,default=fail" usage:"When an artifact doesn't pass a policy: fail the step, or warn"`
+	Ids          string `env:"IDS" usage:"Rode policies to evaluate each artifact against once the build is recorded, separated by the artifact names delimiter"`
+	RodeHost     string `env:"RODE_HOST" usage:"The Rode API host and port. Required when policies are set"`
+	RodeInsecure bool   `env:"RODE_INSECURE" usage:"Connect to the Rode API without TLS"`
+}
+
+type registryConfig struct {
+	DockerConfig string `env:"DOCKER_CONFIG" usage:"Directory containing the Docker config.json with registry credentials, defaults to DOCKER_CONFIG then ~/.docker"`
+	Insecure     bool   `env:"INSECURE" usage:"Connect to the image registry over plain HTTP"`
+	Password     string `env:"PASSWORD" usage:"Password for the image registry, used when resolving digests"`
+	Username     string `env:"USERNAME" usage:"Username for the image registry. When unset, credentials are read from the Docker config"`
+}
+
+type provenanceConfig struct {
+	Attach  bool   `env:"ATTACH" usage:"Reference the provenance by its digest as an additional artifact"`
+	Path    string `env:"PATH" usage:"When set, a SLSA provenance statement for the build is written to this path"`
+	Version string `env:"VERSION,default=v0.2" usage:"The SLSA provenance version to generate, either v0.2 or v1"`
+}
+
+type signingConfig struct {
+	EnvelopePath  string `env:"ENVELOPE_PATH,default=build.dsse.json" usage:"Where to write the signed DSSE envelope when a signing key is set"`
+	Key           string `env:"KEY" usage:"A PEM encoded ECDSA or ed25519 private key used to sign the provenance or build metadata"`
+	KeyPassphrase string `env:"KEY_PASSPHRASE" usage:"The passphrase for a signing key encrypted as PKCS#8"`
 }
 
 type config struct {
-	AccessToken            string                `env:"ACCESS_TOKEN"`
-	ArtifactId             string                `env:"ARTIFACT_ID,required"`
-	ArtifactNames          string                `env:"ARTIFACT_NAMES"`
-	ArtifactNamesDelimiter string                `env:"ARTIFACT_NAMES_DELIMITER,required"`
+	AccessToken            string                `env:"ACCESS_TOKEN" usage:"An access token that will be included in requests to the build collector"`
+	ArtifactId             string                `env:"ARTIFACT_ID" usage:"The identifier of the created artifact. Required unless the artifact type is set"`
+	ArtifactNames          string                `env:"ARTIFACT_NAMES" usage:"A list of alternative names for the artifact. If using Docker, these are any additional tags"`
+	ArtifactNamesDelimiter string                `env:"ARTIFACT_NAMES_DELIMITER,required" usage:"Used to separate artifact names and SBOM paths"`
+	ArtifactName           string                `env:"ARTIFACT_NAME" usage:"The package name, used with the artifact type"`
+	ArtifactNamespace      string                `env:"ARTIFACT_NAMESPACE" usage:"The package namespace (e.g., npm scope or Maven group id), used with the artifact type"`
+	ArtifactQualifiers     string                `env:"ARTIFACT_QUALIFIERS" usage:"Comma or newline separated key=value package url qualifiers, used with the artifact type"`
+	ArtifactType           string                `env:"ARTIFACT_TYPE" usage:"The package type (e.g., npm, maven, pypi), used to build a package url artifact id"`
+	ArtifactVersion        string                `env:"ARTIFACT_VERSION" usage:"The package version, used with the artifact type"`
 	BuildCollector         *buildCollectorConfig `env:",prefix=BUILD_COLLECTOR_"`
-	GitHub                 *githubConfig         `env:",prefix=GITHUB_"`
+	BuildCollectors        string                `env:"BUILD_COLLECTORS" usage:"A YAML list of build collectors to record the build in, each with a name, host, and optionally insecure, caFile and accessToken"`
+	BuildEnd               string                `env:"BUILD_END" usage:"Overrides when the build finished, in RFC 3339 format"`
+	BuildStart             string                `env:"BUILD_START" usage:"Overrides when the build started, in RFC 3339 format"`
+	BuildSteps             string                `env:"BUILD_STEPS" usage:"Names of the job steps that ran the build, used for the build start and end times"`
+	CommitSource           string                `env:"COMMIT_SOURCE,default=sha" usage:"The commit recorded for pull requests in GitHub Actions: sha for the merge commit in GITHUB_SHA, or head for the head of the pull request"`
+	CreatorSource          string                `env:"CREATOR_SOURCE,default=actor" usage:"Where the creator of the build comes from: actor, email, id, author, committer or template"`
+	CreatorTemplate        string                `env:"CREATOR_TEMPLATE" usage:"A Go template for the creator when the creator source is template, e.g. {{.Actor}}@example.com"`
+	Metrics                *metricsConfig        `env:",prefix=METRICS_"`
+	PartialFailure         string                `env:"PARTIAL_FAILURE,default=fail" usage:"When the build is recorded in some of the build collectors but not others: fail the step, or warn"`
+	Policy                 *policyConfig         `env:",prefix=POLICY_"`
+	Preflight              bool                  `env:"PREFLIGHT" usage:"Check each step of connecting to the build collector before recording the build"`
+	Provenance             *provenanceConfig     `env:",prefix=PROVENANCE_"`
+	Provider               string                `env:"CI_PROVIDER" usage:"The CI system to read the build details from: github, gitlab or jenkins. Detected when unset"`
+	Registry               *registryConfig       `env:",prefix=REGISTRY_"`
+	RequestPath            string                `env:"REQUEST_PATH" usage:"When set, the request sent to the build collector is saved to this path so that it can be replayed"`
+	RequireSignedCommit    bool                  `env:"REQUIRE_SIGNED_COMMIT" usage:"Refuse to record the build unless the commit has a verified signature. Only available in GitHub Actions"`
+	ResolveDigest          bool                  `env:"RESOLVE_DIGEST" usage:"Resolve a tag in the artifact id to a digest, keeping the tag as a name"`
+	SbomPaths              string                `env:"SBOM_PATHS" usage:"SPDX or CycloneDX SBOM files (or glob patterns) to associate with the build"`
+	Scope                  string                `env:"SCOPE,default=job" usage:"Record the job running the action (job), or every job in the workflow run (workflow). Only used in GitHub Actions"`
+	Signing                *signingConfig        `env:",prefix=SIGNING_"`
+	Timeout                *timeoutConfig        `env:",prefix=TIMEOUT_"`
+	Tracing                *tracingConfig        `env:",prefix=TRACING_"`
+	WorkflowJobs           string                `env:"WORKFLOW_JOBS" usage:"Names of the jobs to include in the workflow scope, defaults to every other job in the run that has completed"`
 }
 
 type staticCredential struct {
@@ -70,85 +133,151 @@ func (s *staticCredential) RequireTransportSecurity() bool {
 	return s.requireTransportSecurity
 }
 
-func newBuildCollectorClient(c *config) (*grpc.ClientConn, collector.BuildCollectorClient) {
+func buildCollectorDialOptions(t *collectorTarget) ([]grpc.DialOption, error) {
 	dialOptions := []grpc.DialOption{
 		grpc.WithBlock(),
 		grpc.FailOnNonTempDialError(true),
+		grpc.WithUnaryInterceptor(tracingInterceptor),
 	}
-	if c.BuildCollector.Insecure {
+	if t.Insecure {
 		dialOptions = append(dialOptions, grpc.WithInsecure())
 	} else {
-		dialOptions = append(dialOptions, grpc.WithTransportCredentials(credentials.NewTLS(&tls.Config{})))
+		tlsConfig, err := t.tlsConfig()
+		if err != nil {
+			return nil, err
+		}
+		dialOptions = append(dialOptions, grpc.WithTransportCredentials(credentials.NewTLS(tlsConfig)))
 	}
 
-	if c.AccessToken != "" {
+	if t.AccessToken != "" {
 		dialOptions = append(dialOptions, grpc.WithPerRPCCredentials(&staticCredential{
-			token:                    c.AccessToken,
-			requireTransportSecurity: !c.BuildCollector.Insecure,
+			token:                    t.AccessToken,
+			requireTransportSecurity: !t.Insecure,
 		}))
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
-	defer cancel()
-	conn, err := grpc.DialContext(ctx, c.BuildCollector.Host, dialOptions...)
+	return dialOptions, nil
+}
+
+func newBuildCollectorClient(ctx context.Context, c *config, t *collectorTarget) (_ *grpc.ClientConn, _ collector.BuildCollectorClient, err error) {
+	ctx, span := tracer().Start(ctx, "dial build collector", trace.WithAttributes(
+		attribute.String("rode.build_collector", t.Name),
+		semconv.NetPeerNameKey.String(t.Host),
+	))
+	defer func() { endSpan(span, err) }()
+
+	conn, err := dial(ctx, c, t)
 	if err != nil {
-		log.Fatalf("Unable to connect to build collector: %v", err)
+		return nil, nil, err
 	}
 
-	return conn, collector.NewBuildCollectorClient(conn)
+	return conn, collector.NewBuildCollectorClient(conn), nil
 }
 
-func newLogger() (*zap.Logger, error) {
-	return zap.NewDevelopment()
+// dial connects to a gRPC server, either a build collector or the Rode API, within the dial timeout
+func dial(ctx context.Context, c *config, t *collectorTarget) (*grpc.ClientConn, error) {
+	dialOptions, err := buildCollectorDialOptions(t)
+	if err != nil {
+		return nil, err
+	}
+
+	dialCtx, cancel := withTimeout(ctx, c.Timeout.Dial)
+	defer cancel()
+	conn, err := grpc.DialContext(dialCtx, t.Host, dialOptions...)
+	if errors.Is(err, context.DeadlineExceeded) && ctx.Err() == nil {
+		return nil, fmt.Errorf("no connection within %s, set TIMEOUT_DIAL to wait longer", c.Timeout.Dial)
+	}
+
+	return conn, err
 }
 
-func newGitHubClient(c *config) *github.Client {
-	tokenSource := oauth2.StaticTokenSource(
-		&oauth2.Token{
-			AccessToken: c.GitHub.Token,
-		},
-	)
+// version is set at build time, e.g. -ldflags "-X main.version=v0.3.0"
+var version = "dev"
 
-	return github.NewClient(oauth2.NewClient(context.Background(), tokenSource))
+func newLogger() (*zap.Logger, error) {
+	return zap.NewDevelopment()
 }
 
 func setOutputVariable(name, value string) {
-	fmt.Printf("::set-output name=%s::%s", name, value)
+	fmt.Printf("::set-output name=%s::%s\n", name, value)
+}
+
+// annotationEscaper escapes the message of a workflow command, and annotationPropertyEscaper its properties, e.g. the title
+var (
+	annotationEscaper         = strings.NewReplacer("%", "%25", "\r", "%0D", "\n", "%0A")
+	annotationPropertyEscaper = strings.NewReplacer("%", "%25", "\r", "%0D", "\n", "%0A", ":", "%3A", ",", "%2C")
+)
+
+// annotate prints a workflow command that GitHub Actions shows as an error or warning annotation on the run, other CI systems
+// just log it
+func annotate(w io.Writer, level, title, message string) {
+	fmt.Fprintf(w, "::%s title=%s::%s\n", level, annotationPropertyEscaper.Replace(title), annotationEscaper.Replace(message))
 }
 
+// exitCancelled is the exit code when the action is stopped by SIGINT or SIGTERM, e.g. because the workflow run was cancelled, so that it
+// can be told apart from a failure
+const exitCancelled = 130
+
 func fatal(message string) {
 	fmt.Println(message)
 	os.Exit(1)
 }
 
+// exitCode is the exit code for an error: 1, unless the action was interrupted, in which case the error is most likely just the
+// interruption
+func exitCode(ctx context.Context) int {
+	if errors.Is(ctx.Err(), context.Canceled) {
+		return exitCancelled
+	}
+
+	return 1
+}
+
 func main() {
-	ctx := context.Background()
-	c := &config{}
-	if err := envconfig.Process(ctx, c); err != nil {
-		fatal(fmt.Sprintf("unable to build config: %s", err))
+	commands := newCommands()
+	args := os.Args[1:]
+	if len(args) > 0 && (args[0] == "help" || args[0] == "-h" || args[0] == "--help") {
+		printUsage(os.Stdout, commands)
+		return
 	}
 
-	logger, err := newLogger()
-	if err != nil {
-		fatal(fmt.Sprintf("failed to create logger: %s", err))
+	name := "run"
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		name, args = args[0], args[1:]
 	}
 
-	conn, client := newBuildCollectorClient(c)
-	defer conn.Close()
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-ctx.Done()
+		// a second signal stops the action straight away
+		stop()
+	}()
 
-	githubClient := newGitHubClient(c)
+	metrics := newBuildMetrics()
+	ctx = withMetrics(ctx, metrics)
+	for _, c := range commands {
+		if c.name != name {
+			continue
+		}
 
-	action := &createBuildOccurrenceAction{
-		actions: githubClient.Actions,
-		config:  c,
-		client:  client,
-		logger:  logger,
-	}
+		err := c.run(ctx, args)
+		if errors.Is(err, flag.ErrHelp) {
+			return
+		}
 
-	occurrenceId, err := action.Run(ctx)
-	if err != nil {
-		fatal(err.Error())
+		// a Pushgateway that's unavailable shouldn't fail the build
+		if err := metrics.push(ctx, err); err != nil {
+			fmt.Println(err.Error())
+		}
+
+		if err != nil {
+			fmt.Println(err.Error())
+			os.Exit(exitCode(ctx))
+		}
+
+		return
 	}
 
-	setOutputVariable("id", occurrenceId)
+	printUsage(os.Stderr, commands)
+	fatal(fmt.Sprintf("unknown command %q", name))
 }
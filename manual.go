@@ -0,0 +1,108 @@
+// Copyright 2021 The Rode Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+const (
+	providerManual  = "manual"
+	manualBuildType = "https://github.com/rode/create-build-occurrence-action/manual@v1"
+)
+
+// manualProvider describes a build entirely from values given on the command line, for local testing or CI systems without a provider
+type manualProvider struct {
+	BuilderId    string
+	BuildEnd     string
+	BuildStart   string
+	CommitId     string
+	CommitUri    string
+	Creator      string
+	LogsUri      string
+	ProvenanceId string
+	Repository   string
+}
+
+func (m *manualProvider) metricLabels() metricLabels {
+	return metricLabels{Repo: m.Repository}
+}
+
+func (m *manualProvider) validate() configErrors {
+	var problems configErrors
+	if m.Repository == "" {
+		problems = append(problems, "-repository: must be set")
+	}
+
+	if m.CommitId == "" {
+		problems = append(problems, "-commit-id: must be set")
+	}
+
+	return append(problems, validateUrl("-logs-uri", m.LogsUri)...)
+}
+
+func (m *manualProvider) BuildMetadata(_ context.Context) (*buildMetadata, error) {
+	if m.Repository == "" {
+		return nil, fmt.Errorf("repository is required")
+	}
+
+	if m.CommitId == "" {
+		return nil, fmt.Errorf("commit id is required")
+	}
+
+	buildEnd := time.Now()
+	if m.BuildEnd != "" {
+		t, err := time.Parse(time.RFC3339, m.BuildEnd)
+		if err != nil {
+			return nil, fmt.Errorf("invalid build end %q: %s", m.BuildEnd, err)
+		}
+		buildEnd = t
+	}
+
+	// without a start time, the build is recorded as if it were instantaneous
+	buildStart := buildEnd
+	if m.BuildStart != "" {
+		t, err := time.Parse(time.RFC3339, m.BuildStart)
+		if err != nil {
+			return nil, fmt.Errorf("invalid build start %q: %s", m.BuildStart, err)
+		}
+		buildStart = t
+	}
+
+	if buildStart.After(buildEnd) {
+		return nil, fmt.Errorf("build start must not be after build end")
+	}
+
+	return &buildMetadata{
+		Actor:        m.Creator,
+		BuildEnd:     buildEnd,
+		BuildStart:   buildStart,
+		CommitId:     m.CommitId,
+		CommitUri:    m.CommitUri,
+		LogsUri:      m.LogsUri,
+		ProvenanceId: m.ProvenanceId,
+		Repository:   m.Repository,
+		Invocation: &buildInvocation{
+			Id:           m.ProvenanceId,
+			Provider:     providerManual,
+			BuilderId:    m.BuilderId,
+			BuildType:    manualBuildType,
+			BuildTypeV02: manualBuildType,
+			Environment:  map[string]interface{}{},
+		},
+	}, nil
+}
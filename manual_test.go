@@ -0,0 +1,125 @@
+// Copyright 2021 The Rode Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("manualProvider", func() {
+	var (
+		provider *manualProvider
+
+		actualMetadata *buildMetadata
+		actualError    error
+	)
+
+	BeforeEach(func() {
+		provider = &manualProvider{
+			BuilderId:    "https://ci.example.com",
+			BuildEnd:     "2021-06-01T12:45:00Z",
+			BuildStart:   "2021-06-01T12:30:00Z",
+			CommitId:     "foobar",
+			CommitUri:    "https://github.com/rode/demo-app/commit/foobar",
+			Creator:      "jdoe",
+			LogsUri:      "https://ci.example.com/builds/12/logs",
+			ProvenanceId: "https://ci.example.com/builds/12",
+			Repository:   "https://github.com/rode/demo-app",
+		}
+	})
+
+	JustBeforeEach(func() {
+		actualMetadata, actualError = provider.BuildMetadata(context.Background())
+	})
+
+	It("should use the given values", func() {
+		Expect(actualError).NotTo(HaveOccurred())
+		Expect(actualMetadata).To(Equal(&buildMetadata{
+			Actor:        "jdoe",
+			BuildEnd:     time.Date(2021, 6, 1, 12, 45, 0, 0, time.UTC),
+			BuildStart:   time.Date(2021, 6, 1, 12, 30, 0, 0, time.UTC),
+			CommitId:     "foobar",
+			CommitUri:    "https://github.com/rode/demo-app/commit/foobar",
+			LogsUri:      "https://ci.example.com/builds/12/logs",
+			ProvenanceId: "https://ci.example.com/builds/12",
+			Repository:   "https://github.com/rode/demo-app",
+			Invocation: &buildInvocation{
+				Id:           "https://ci.example.com/builds/12",
+				Provider:     providerManual,
+				BuilderId:    "https://ci.example.com",
+				BuildType:    manualBuildType,
+				BuildTypeV02: manualBuildType,
+				Environment:  map[string]interface{}{},
+			},
+		}))
+	})
+
+	When("the build times are omitted", func() {
+		BeforeEach(func() {
+			provider.BuildStart = ""
+			provider.BuildEnd = ""
+		})
+
+		It("should record the build as finishing now", func() {
+			Expect(actualError).NotTo(HaveOccurred())
+			Expect(actualMetadata.BuildEnd).To(BeTemporally("~", time.Now(), time.Second))
+			Expect(actualMetadata.BuildStart).To(Equal(actualMetadata.BuildEnd))
+		})
+	})
+
+	When("the build start is after the build end", func() {
+		BeforeEach(func() {
+			provider.BuildStart = "2021-06-01T13:00:00Z"
+		})
+
+		It("should return an error", func() {
+			Expect(actualError).To(MatchError("build start must not be after build end"))
+		})
+	})
+
+	When("a time is not in RFC 3339 format", func() {
+		BeforeEach(func() {
+			provider.BuildEnd = "yesterday"
+		})
+
+		It("should return an error", func() {
+			Expect(actualError).To(MatchError(ContainSubstring("invalid build end")))
+		})
+	})
+
+	When("the repository is missing", func() {
+		BeforeEach(func() {
+			provider.Repository = ""
+		})
+
+		It("should return an error", func() {
+			Expect(actualError).To(MatchError("repository is required"))
+		})
+	})
+
+	When("the commit is missing", func() {
+		BeforeEach(func() {
+			provider.CommitId = ""
+		})
+
+		It("should return an error", func() {
+			Expect(actualError).To(MatchError("commit id is required"))
+		})
+	})
+})
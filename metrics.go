@@ -0,0 +1,203 @@
+// Copyright 2021 The Rode Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/push"
+)
+
+const defaultMetricsJob = "create_build_occurrence_action"
+
+// the stages of creating a build occurrence, used as the error class of a failure
+const (
+	stageConfig             = "config"
+	stageCIProvider         = "ci_provider"
+	stageCommitVerification = "commit_verification"
+	stageArtifact           = "artifact"
+	stageBuildCollector     = "build_collector"
+
+	errorClassTimeout   = "timeout"
+	errorClassCancelled = "cancelled"
+)
+
+// metricLabels identify where a build ran. They're the grouping key in the Pushgateway, so that each job's metrics are kept apart.
+type metricLabels struct {
+	Repo     string
+	Workflow string
+	Job      string
+}
+
+// labeler is implemented by CI providers that can name the repository, workflow and job from their environment, before any API is
+// called, so that failures are labelled too
+type labeler interface {
+	metricLabels() metricLabels
+}
+
+func providerLabels(provider ciProvider) metricLabels {
+	if l, ok := provider.(labeler); ok {
+		return l.metricLabels()
+	}
+
+	return metricLabels{}
+}
+
+// buildMetrics are pushed to a Pushgateway when the action exits, as the action doesn't live long enough to be scraped
+type buildMetrics struct {
+	config   *config
+	labels   metricLabels
+	stage    string
+	recorded bool
+	registry *prometheus.Registry
+
+	created     prometheus.Counter
+	failures    *prometheus.CounterVec
+	ciLookup    prometheus.Histogram
+	createBuild prometheus.Histogram
+}
+
+func newBuildMetrics() *buildMetrics {
+	m := &buildMetrics{
+		registry: prometheus.NewRegistry(),
+		created: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "rode_build_occurrences_created_total",
+			Help: "Build occurrences created in the build collector.",
+		}),
+		failures: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "rode_build_occurrence_failures_total",
+			Help: "Build occurrences that couldn't be created, by the stage that failed, or timeout or cancelled.",
+		}, []string{"error_class"}),
+		ciLookup: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Name:    "rode_build_ci_lookup_duration_seconds",
+			Help:    "Time taken to fetch the build details from the CI system, e.g. the GitHub API.",
+			Buckets: prometheus.DefBuckets,
+		}),
+		createBuild: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Name:    "rode_build_create_duration_seconds",
+			Help:    "Time taken by the build collector to create the build occurrence.",
+			Buckets: prometheus.DefBuckets,
+		}),
+	}
+	m.registry.MustRegister(m.created, m.failures, m.ciLookup, m.createBuild)
+
+	return m
+}
+
+type metricsKey struct{}
+
+func withMetrics(ctx context.Context, m *buildMetrics) context.Context {
+	return context.WithValue(ctx, metricsKey{}, m)
+}
+
+// metricsFrom returns nil when the context has no metrics, the methods of buildMetrics do nothing on nil
+func metricsFrom(ctx context.Context) *buildMetrics {
+	m, _ := ctx.Value(metricsKey{}).(*buildMetrics)
+
+	return m
+}
+
+// track is called by commands that create a build occurrence once the config has been loaded, only those commands push metrics
+func (m *buildMetrics) track(c *config, labels metricLabels) {
+	if m == nil {
+		return
+	}
+
+	m.config = c
+	m.labels = labels
+	m.stage = stageConfig
+}
+
+// enter records the stage the action has reached, a failure is counted against the last stage entered
+func (m *buildMetrics) enter(stage string) {
+	if m == nil {
+		return
+	}
+
+	m.stage = stage
+}
+
+func (m *buildMetrics) observeCILookup(d time.Duration) {
+	if m == nil {
+		return
+	}
+
+	m.ciLookup.Observe(d.Seconds())
+}
+
+func (m *buildMetrics) observeCreateBuild(d time.Duration) {
+	if m == nil {
+		return
+	}
+
+	m.createBuild.Observe(d.Seconds())
+}
+
+// errorClass is the stage that failed, unless the action ran out of time or was cancelled. ctx is the context of the whole action.
+func (m *buildMetrics) errorClass(ctx context.Context) string {
+	switch {
+	case errors.Is(ctx.Err(), context.Canceled):
+		return errorClassCancelled
+	case errors.Is(ctx.Err(), context.DeadlineExceeded):
+		return errorClassTimeout
+	}
+
+	return m.stage
+}
+
+// record counts the result of creating the build occurrence, only the first result is counted. Commands record the result with
+// the context that has the total timeout, so that running out of time is told apart from the stage that was cut short.
+func (m *buildMetrics) record(ctx context.Context, err error) {
+	if m == nil || m.recorded {
+		return
+	}
+	m.recorded = true
+
+	if err != nil {
+		m.failures.WithLabelValues(m.errorClass(ctx)).Inc()
+	} else {
+		m.created.Inc()
+	}
+}
+
+// push sends the metrics to the Pushgateway, counting the result if the command hasn't. It does nothing unless a command tracked
+// the build and a Pushgateway is configured.
+func (m *buildMetrics) push(ctx context.Context, err error) error {
+	if m == nil || m.config == nil || m.config.Metrics.PushgatewayUrl == "" {
+		return nil
+	}
+	m.record(ctx, err)
+
+	job := m.labels.Job
+	if job == "" {
+		job = defaultMetricsJob
+	}
+
+	// the action's context may already be cancelled or out of time, the client timeout limits the push instead
+	pusher := push.New(m.config.Metrics.PushgatewayUrl, job).
+		Gatherer(m.registry).
+		Grouping("repo", m.labels.Repo).
+		Grouping("workflow", m.labels.Workflow).
+		Client(newHTTPClient(m.config.Timeout))
+	if err := pusher.Push(); err != nil {
+		return fmt.Errorf("unable to push metrics: %s", err)
+	}
+
+	return nil
+}
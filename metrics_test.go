@@ -0,0 +1,202 @@
+// Copyright 2021 The Rode Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	dto "github.com/prometheus/client_model/go"
+)
+
+func histogramCount(h prometheus.Histogram) uint64 {
+	metric := &dto.Metric{}
+	Expect(h.Write(metric)).To(Succeed())
+
+	return metric.GetHistogram().GetSampleCount()
+}
+
+var _ = Describe("buildMetrics", func() {
+	var (
+		ctx     context.Context
+		metrics *buildMetrics
+		conf    *config
+	)
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		metrics = newBuildMetrics()
+		conf = &config{Metrics: &metricsConfig{}, Timeout: &timeoutConfig{Rpc: time.Second}}
+	})
+
+	Describe("record", func() {
+		BeforeEach(func() {
+			metrics.track(conf, metricLabels{})
+		})
+
+		It("should count a build occurrence that was created", func() {
+			metrics.record(ctx, nil)
+
+			Expect(testutil.ToFloat64(metrics.created)).To(Equal(1.0))
+			Expect(testutil.CollectAndCount(metrics.failures)).To(BeZero())
+		})
+
+		It("should count a failure against the stage that failed", func() {
+			metrics.enter(stageArtifact)
+
+			metrics.record(ctx, errors.New("error resolving artifact digest"))
+
+			Expect(testutil.ToFloat64(metrics.failures.WithLabelValues(stageArtifact))).To(Equal(1.0))
+			Expect(testutil.ToFloat64(metrics.created)).To(BeZero())
+		})
+
+		It("should count a config failure when no stage was entered", func() {
+			metrics.record(ctx, errors.New("ARTIFACT_ID: must be set"))
+
+			Expect(testutil.ToFloat64(metrics.failures.WithLabelValues(stageConfig))).To(Equal(1.0))
+		})
+
+		It("should tell timeouts and cancellation apart from the stage", func() {
+			metrics.enter(stageBuildCollector)
+			timedOut, cancel := context.WithTimeout(ctx, 0)
+			defer cancel()
+
+			metrics.record(timedOut, errors.New("error creating build occurrence"))
+
+			Expect(testutil.ToFloat64(metrics.failures.WithLabelValues(errorClassTimeout))).To(Equal(1.0))
+
+			cancelled, cancel := context.WithCancel(ctx)
+			cancel()
+			Expect(newBuildMetrics().errorClass(cancelled)).To(Equal(errorClassCancelled))
+		})
+
+		It("should only count the first result", func() {
+			metrics.record(ctx, nil)
+			metrics.record(ctx, errors.New("unable to push"))
+
+			Expect(testutil.ToFloat64(metrics.created)).To(Equal(1.0))
+			Expect(testutil.CollectAndCount(metrics.failures)).To(BeZero())
+		})
+	})
+
+	Describe("push", func() {
+		var (
+			requests chan *http.Request
+			bodies   chan []byte
+			gateway  *httptest.Server
+		)
+
+		BeforeEach(func() {
+			requests = make(chan *http.Request, 1)
+			bodies = make(chan []byte, 1)
+			gateway = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				body, _ := io.ReadAll(r.Body)
+				requests <- r
+				bodies <- body
+			}))
+			conf.Metrics.PushgatewayUrl = gateway.URL
+		})
+
+		AfterEach(func() {
+			gateway.Close()
+		})
+
+		It("should push the metrics grouped by repository, workflow and job", func() {
+			metrics.track(conf, metricLabels{Repo: "https://github.com/rode/demo-app", Workflow: "ci", Job: "build"})
+
+			Expect(metrics.push(ctx, nil)).To(Succeed())
+
+			var request *http.Request
+			Eventually(requests).Should(Receive(&request))
+			Expect(request.Method).To(Equal(http.MethodPut))
+			// the grouping labels are in no particular order
+			Expect(request.URL.Path).To(HavePrefix("/metrics/job/build/"))
+			Expect(request.URL.Path).To(ContainSubstring("/repo@base64/aHR0cHM6Ly9naXRodWIuY29tL3JvZGUvZGVtby1hcHA"))
+			Expect(request.URL.Path).To(ContainSubstring("/workflow/ci"))
+			Expect(testutil.ToFloat64(metrics.created)).To(Equal(1.0))
+			Eventually(bodies).Should(Receive(ContainSubstring("rode_build_occurrences_created_total")))
+		})
+
+		It("should use a default job when the CI system has none", func() {
+			metrics.track(conf, metricLabels{Repo: "rode"})
+
+			Expect(metrics.push(ctx, errors.New("error creating build occurrence"))).To(Succeed())
+
+			var request *http.Request
+			Eventually(requests).Should(Receive(&request))
+			Expect(request.URL.Path).To(HavePrefix("/metrics/job/create_build_occurrence_action/"))
+			Expect(request.URL.Path).To(ContainSubstring("/repo/rode"))
+			Expect(request.URL.Path).To(ContainSubstring("/workflow@base64/="))
+			Expect(testutil.ToFloat64(metrics.failures.WithLabelValues(stageConfig))).To(Equal(1.0))
+		})
+
+		It("should report an unavailable Pushgateway", func() {
+			gateway.Close()
+			metrics.track(conf, metricLabels{})
+
+			Expect(metrics.push(ctx, nil)).To(MatchError(HavePrefix("unable to push metrics")))
+		})
+
+		It("should not push unless the build was tracked", func() {
+			Expect(metrics.push(ctx, nil)).To(Succeed())
+
+			Consistently(requests, 50*time.Millisecond).ShouldNot(Receive())
+		})
+
+		It("should not push without a Pushgateway", func() {
+			conf.Metrics.PushgatewayUrl = ""
+			metrics.track(conf, metricLabels{})
+
+			Expect(metrics.push(ctx, nil)).To(Succeed())
+
+			Consistently(requests, 50*time.Millisecond).ShouldNot(Receive())
+		})
+	})
+
+	DescribeTable("providerLabels",
+		func(provider ciProvider, expected metricLabels) {
+			Expect(providerLabels(provider)).To(Equal(expected))
+		},
+		Entry("GitHub", &githubProvider{config: &githubConfig{ServerUrl: "https://github.com", RepoSlug: "rode/demo-app", Workflow: "ci", JobId: "build"}},
+			metricLabels{Repo: "https://github.com/rode/demo-app", Workflow: "ci", Job: "build"}),
+		Entry("GitLab", &gitlabProvider{config: &gitlabConfig{ProjectUrl: "https://gitlab.com/rode/demo-app", JobName: "build"}},
+			metricLabels{Repo: "https://gitlab.com/rode/demo-app", Job: "build"}),
+		Entry("Jenkins", &jenkinsProvider{config: &jenkinsConfig{GitUrl: "https://github.com/rode/demo-app.git", JobName: "demo-app/main"}},
+			metricLabels{Repo: "https://github.com/rode/demo-app", Job: "demo-app/main"}),
+		Entry("Jenkins with an ssh git url", &jenkinsProvider{config: &jenkinsConfig{GitUrl: "git@github.com:rode/demo-app.git", JobName: "demo-app/main"}},
+			metricLabels{Repo: "https://github.com/rode/demo-app", Job: "demo-app/main"}),
+		Entry("manual", &manualProvider{Repository: "https://github.com/rode/demo-app"}, metricLabels{Repo: "https://github.com/rode/demo-app"}),
+	)
+
+	It("should do nothing when there are no metrics", func() {
+		var metrics *buildMetrics
+
+		metrics.track(conf, metricLabels{})
+		metrics.enter(stageArtifact)
+		metrics.observeCILookup(time.Second)
+		metrics.observeCreateBuild(time.Second)
+		metrics.record(ctx, nil)
+		Expect(metrics.push(ctx, nil)).To(Succeed())
+	})
+})
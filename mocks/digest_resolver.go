@@ -0,0 +1,115 @@
+// Code generated by counterfeiter. DO NOT EDIT.
+package mocks
+
+import (
+	"context"
+	"sync"
+)
+
+type FakeDigestResolver struct {
+	ResolveDigestStub        func(context.Context, string) (string, error)
+	resolveDigestMutex       sync.RWMutex
+	resolveDigestArgsForCall []struct {
+		arg1 context.Context
+		arg2 string
+	}
+	resolveDigestReturns struct {
+		result1 string
+		result2 error
+	}
+	resolveDigestReturnsOnCall map[int]struct {
+		result1 string
+		result2 error
+	}
+	invocations      map[string][][]interface{}
+	invocationsMutex sync.RWMutex
+}
+
+func (fake *FakeDigestResolver) ResolveDigest(arg1 context.Context, arg2 string) (string, error) {
+	fake.resolveDigestMutex.Lock()
+	ret, specificReturn := fake.resolveDigestReturnsOnCall[len(fake.resolveDigestArgsForCall)]
+	fake.resolveDigestArgsForCall = append(fake.resolveDigestArgsForCall, struct {
+		arg1 context.Context
+		arg2 string
+	}{arg1, arg2})
+	stub := fake.ResolveDigestStub
+	fakeReturns := fake.resolveDigestReturns
+	fake.recordInvocation("ResolveDigest", []interface{}{arg1, arg2})
+	fake.resolveDigestMutex.Unlock()
+	if stub != nil {
+		return stub(arg1, arg2)
+	}
+	if specificReturn {
+		return ret.result1, ret.result2
+	}
+	return fakeReturns.result1, fakeReturns.result2
+}
+
+func (fake *FakeDigestResolver) ResolveDigestCallCount() int {
+	fake.resolveDigestMutex.RLock()
+	defer fake.resolveDigestMutex.RUnlock()
+	return len(fake.resolveDigestArgsForCall)
+}
+
+func (fake *FakeDigestResolver) ResolveDigestCalls(stub func(context.Context, string) (string, error)) {
+	fake.resolveDigestMutex.Lock()
+	defer fake.resolveDigestMutex.Unlock()
+	fake.ResolveDigestStub = stub
+}
+
+func (fake *FakeDigestResolver) ResolveDigestArgsForCall(i int) (context.Context, string) {
+	fake.resolveDigestMutex.RLock()
+	defer fake.resolveDigestMutex.RUnlock()
+	argsForCall := fake.resolveDigestArgsForCall[i]
+	return argsForCall.arg1, argsForCall.arg2
+}
+
+func (fake *FakeDigestResolver) ResolveDigestReturns(result1 string, result2 error) {
+	fake.resolveDigestMutex.Lock()
+	defer fake.resolveDigestMutex.Unlock()
+	fake.ResolveDigestStub = nil
+	fake.resolveDigestReturns = struct {
+		result1 string
+		result2 error
+	}{result1, result2}
+}
+
+func (fake *FakeDigestResolver) ResolveDigestReturnsOnCall(i int, result1 string, result2 error) {
+	fake.resolveDigestMutex.Lock()
+	defer fake.resolveDigestMutex.Unlock()
+	fake.ResolveDigestStub = nil
+	if fake.resolveDigestReturnsOnCall == nil {
+		fake.resolveDigestReturnsOnCall = make(map[int]struct {
+			result1 string
+			result2 error
+		})
+	}
+	fake.resolveDigestReturnsOnCall[i] = struct {
+		result1 string
+		result2 error
+	}{result1, result2}
+}
+
+func (fake *FakeDigestResolver) Invocations() map[string][][]interface{} {
+	fake.invocationsMutex.RLock()
+	defer fake.invocationsMutex.RUnlock()
+	fake.resolveDigestMutex.RLock()
+	defer fake.resolveDigestMutex.RUnlock()
+	copiedInvocations := map[string][][]interface{}{}
+	for key, value := range fake.invocations {
+		copiedInvocations[key] = value
+	}
+	return copiedInvocations
+}
+
+func (fake *FakeDigestResolver) recordInvocation(key string, args []interface{}) {
+	fake.invocationsMutex.Lock()
+	defer fake.invocationsMutex.Unlock()
+	if fake.invocations == nil {
+		fake.invocations = map[string][][]interface{}{}
+	}
+	if fake.invocations[key] == nil {
+		fake.invocations[key] = [][]interface{}{}
+	}
+	fake.invocations[key] = append(fake.invocations[key], args)
+}
@@ -0,0 +1,170 @@
+// Copyright 2021 The Rode Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	collector "github.com/rode/collector-build/proto/v1alpha1"
+	rode "github.com/rode/create-build-occurrence-action/proto/rode/v1alpha1"
+	semconv "go.opentelemetry.io/otel/semconv/v1.4.0"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc"
+)
+
+const (
+	policyFailureFail = "fail"
+	policyFailureWarn = "warn"
+)
+
+// rodeTarget is the Rode API that policies are evaluated by, it's dialed the same way as a build collector
+func rodeTarget(c *config) *collectorTarget {
+	accessToken := c.Policy.AccessToken
+	if accessToken == "" {
+		accessToken = c.AccessToken
+	}
+
+	return &collectorTarget{
+		Name:        "rode",
+		Host:        c.Policy.RodeHost,
+		Insecure:    c.Policy.RodeInsecure,
+		AccessToken: accessToken,
+		index:       -1,
+	}
+}
+
+func newRodeClient(ctx context.Context, c *config) (_ *grpc.ClientConn, _ rode.RodeClient, err error) {
+	ctx, span := tracer().Start(ctx, "dial Rode", trace.WithAttributes(semconv.NetPeerNameKey.String(c.Policy.RodeHost)))
+	defer func() { endSpan(span, err) }()
+
+	conn, err := dial(ctx, c, rodeTarget(c))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return conn, rode.NewRodeClient(conn), nil
+}
+
+func validatePolicy(c *config) configErrors {
+	var problems configErrors
+	switch c.Policy.Failure {
+	case "", policyFailureFail, policyFailureWarn:
+	default:
+		problems = append(problems, fmt.Sprintf("POLICY_FAILURE: %q is not supported, expected %s or %s", c.Policy.Failure, policyFailureFail, policyFailureWarn))
+	}
+
+	if c.Policy.Ids == "" {
+		return problems
+	}
+
+	if c.Policy.RodeHost == "" {
+		return append(problems, "POLICY_RODE_HOST: must be set to evaluate POLICY_IDS")
+	}
+
+	return append(problems, validateHostPort("POLICY_RODE_HOST", c.Policy.RodeHost)...)
+}
+
+// evaluatePolicies evaluates the artifact, by its id, against each of the policies once the build has been recorded. Its names
+// are tags or other aliases of the same artifact, so they aren't evaluated separately. The policyPassed and policyResults outputs
+// are set, and violations are annotated. Whether an artifact that doesn't pass is an error depends on POLICY_FAILURE. ctx is the
+// context of the whole action.
+func (a *createBuildOccurrenceAction) evaluatePolicies(ctx context.Context, artifact *collector.Artifact) error {
+	policies := splitList(a.config.Policy.Ids, a.config.ArtifactNamesDelimiter)
+	results := map[string]map[string]bool{artifact.Id: {}}
+	var failures []string
+	for _, policy := range policies {
+		a.logger.Info(fmt.Sprintf("Evaluating %s against policy %s", artifact.Id, policy))
+		response, err := a.evaluatePolicy(ctx, policy, artifact.Id)
+		if err != nil {
+			return fmt.Errorf("error evaluating policy %s for %s: %s", policy, artifact.Id, describeTimeout(ctx, err, a.config.Timeout))
+		}
+
+		results[artifact.Id][policy] = response.Pass
+		if response.Pass {
+			a.logger.Info(fmt.Sprintf("%s passed policy %s", artifact.Id, policy))
+			continue
+		}
+
+		failures = append(failures, fmt.Sprintf("%s (policy %s)", artifact.Id, policy))
+		a.annotateViolations(policy, artifact.Id, response)
+	}
+
+	contents, err := json.Marshal(results)
+	if err != nil {
+		return err
+	}
+	a.setOutput("policyResults", string(contents))
+	a.setOutput("policyPassed", strconv.FormatBool(len(failures) == 0))
+
+	if len(failures) == 0 {
+		return nil
+	}
+
+	err = fmt.Errorf("the build was recorded, but these artifacts didn't pass policy: %s", strings.Join(failures, ", "))
+	if a.config.Policy.Failure == policyFailureWarn {
+		a.logger.Warn(err.Error())
+		return nil
+	}
+
+	return err
+}
+
+func (a *createBuildOccurrenceAction) evaluatePolicy(ctx context.Context, policy, artifactId string) (*rode.EvaluatePolicyResponse, error) {
+	rpcCtx, cancel := withTimeout(ctx, a.config.Timeout.Rpc)
+	defer cancel()
+
+	return a.policy.EvaluatePolicy(rpcCtx, &rode.EvaluatePolicyRequest{
+		Policy:      policy,
+		ResourceUri: artifactId,
+	})
+}
+
+// annotateViolations adds an annotation for each rule of the policy that the artifact broke, as an error unless POLICY_FAILURE
+// is warn
+func (a *createBuildOccurrenceAction) annotateViolations(policy, artifactId string, response *rode.EvaluatePolicyResponse) {
+	level := "error"
+	if a.config.Policy.Failure == policyFailureWarn {
+		level = "warning"
+	}
+
+	annotated := false
+	for _, result := range response.Result {
+		for _, violation := range result.Violations {
+			if violation.Pass {
+				continue
+			}
+
+			name := violation.Name
+			if name == "" {
+				name = violation.Id
+			}
+			message := fmt.Sprintf("%s: %s", artifactId, violation.Message)
+			if violation.Link != "" {
+				message += "\n" + violation.Link
+			}
+
+			annotate(a.annotations, level, fmt.Sprintf("Policy %s: %s", policy, name), message)
+			annotated = true
+		}
+	}
+
+	if !annotated {
+		annotate(a.annotations, level, fmt.Sprintf("Policy %s", policy), fmt.Sprintf("%s didn't pass the policy", artifactId))
+	}
+}
@@ -0,0 +1,258 @@
+// Copyright 2021 The Rode Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	collector "github.com/rode/collector-build/proto/v1alpha1"
+	rode "github.com/rode/create-build-occurrence-action/proto/rode/v1alpha1"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// policyServer is a fake Rode API with canned evaluations, keyed by policy and then resource uri. Anything else passes.
+type policyServer struct {
+	rode.UnimplementedRodeServer
+	token       string
+	evaluations map[string]map[string]*rode.EvaluatePolicyResponse
+	requests    chan *rode.EvaluatePolicyRequest
+}
+
+func (p *policyServer) EvaluatePolicy(ctx context.Context, request *rode.EvaluatePolicyRequest) (*rode.EvaluatePolicyResponse, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	if len(md.Get("authorization")) == 0 || md.Get("authorization")[0] != "Bearer "+p.token {
+		return nil, status.Error(codes.Unauthenticated, "invalid token")
+	}
+	p.requests <- request
+
+	if response, ok := p.evaluations[request.Policy][request.ResourceUri]; ok {
+		return response, nil
+	}
+
+	return &rode.EvaluatePolicyResponse{Pass: true}, nil
+}
+
+func startRode(server rode.RodeServer) (*grpc.Server, string) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	Expect(err).NotTo(HaveOccurred())
+
+	s := grpc.NewServer()
+	rode.RegisterRodeServer(s, server)
+	go s.Serve(listener)
+
+	return s, listener.Addr().String()
+}
+
+var _ = Describe("policy", func() {
+	var (
+		ctx         context.Context
+		conf        *config
+		rodeServer  *grpc.Server
+		policies    *policyServer
+		annotations *bytes.Buffer
+		artifact    *collector.Artifact
+		conns       []*grpc.ClientConn
+	)
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		policies = &policyServer{
+			token:       "rode-token",
+			evaluations: map[string]map[string]*rode.EvaluatePolicyResponse{},
+			requests:    make(chan *rode.EvaluatePolicyRequest, 10),
+		}
+		var host string
+		rodeServer, host = startRode(policies)
+		annotations = &bytes.Buffer{}
+		artifact = &collector.Artifact{
+			Id:    "harbor.example.com/rode/app@sha256:123",
+			Names: []string{"harbor.example.com/rode/app:v1.0.0"},
+		}
+
+		conf = &config{
+			AccessToken:            "rode-token",
+			ArtifactNamesDelimiter: "\n",
+			Policy: &policyConfig{
+				Ids:          "image-policy\nsbom-policy",
+				RodeHost:     host,
+				RodeInsecure: true,
+			},
+			Timeout: &timeoutConfig{Dial: time.Second, Rpc: time.Second},
+		}
+	})
+
+	AfterEach(func() {
+		for _, conn := range conns {
+			conn.Close()
+		}
+		conns = nil
+		rodeServer.Stop()
+	})
+
+	newPolicyAction := func() *createBuildOccurrenceAction {
+		conn, client, err := newRodeClient(ctx, conf)
+		Expect(err).NotTo(HaveOccurred())
+		conns = append(conns, conn)
+
+		return &createBuildOccurrenceAction{
+			annotations: annotations,
+			config:      conf,
+			logger:      logger,
+			policy:      client,
+		}
+	}
+
+	violation := &rode.EvaluatePolicyResponse{
+		Result: []*rode.EvaluatePolicyResult{{
+			Violations: []*rode.EvaluatePolicyViolation{
+				{Name: "digest", Message: "the image is referenced by its digest", Pass: true},
+				{Name: "signed", Message: "the image isn't signed", Link: "https://rode.example.com/policies/image-policy", Pass: false},
+			},
+		}},
+	}
+
+	It("should evaluate the artifact id against each policy, but not its names", func() {
+		action := newPolicyAction()
+
+		Expect(action.evaluatePolicies(ctx, artifact)).To(Succeed())
+
+		var requests []*rode.EvaluatePolicyRequest
+		for i := 0; i < 2; i++ {
+			var request *rode.EvaluatePolicyRequest
+			Expect(policies.requests).To(Receive(&request))
+			requests = append(requests, request)
+		}
+		Expect(policies.requests).NotTo(Receive())
+		Expect(requests[0].Policy).To(Equal("image-policy"))
+		Expect(requests[0].ResourceUri).To(Equal("harbor.example.com/rode/app@sha256:123"))
+		Expect(requests[1].Policy).To(Equal("sbom-policy"))
+		Expect(requests[1].ResourceUri).To(Equal("harbor.example.com/rode/app@sha256:123"))
+		Expect(action.outputs["policyPassed"]).To(Equal("true"))
+		Expect(action.outputs["policyResults"]).To(MatchJSON(`{
+			"harbor.example.com/rode/app@sha256:123": {"image-policy": true, "sbom-policy": true}
+		}`))
+		Expect(annotations.String()).To(BeEmpty())
+	})
+
+	When("an artifact doesn't pass a policy", func() {
+		BeforeEach(func() {
+			policies.evaluations["image-policy"] = map[string]*rode.EvaluatePolicyResponse{
+				"harbor.example.com/rode/app@sha256:123": violation,
+			}
+		})
+
+		It("should fail and annotate the violations", func() {
+			action := newPolicyAction()
+
+			err := action.evaluatePolicies(ctx, artifact)
+
+			Expect(err).To(MatchError("the build was recorded, but these artifacts didn't pass policy: harbor.example.com/rode/app@sha256:123 (policy image-policy)"))
+			Expect(action.outputs["policyPassed"]).To(Equal("false"))
+			Expect(action.outputs["policyResults"]).To(MatchJSON(`{
+				"harbor.example.com/rode/app@sha256:123": {"image-policy": false, "sbom-policy": true}
+			}`))
+			Expect(annotations.String()).To(Equal("::error title=Policy image-policy%3A signed::harbor.example.com/rode/app@sha256:123: the image isn't signed%0Ahttps://rode.example.com/policies/image-policy\n"))
+		})
+
+		It("should only warn when POLICY_FAILURE is warn", func() {
+			conf.Policy.Failure = policyFailureWarn
+			action := newPolicyAction()
+
+			Expect(action.evaluatePolicies(ctx, artifact)).To(Succeed())
+			Expect(action.outputs["policyPassed"]).To(Equal("false"))
+			Expect(annotations.String()).To(HavePrefix("::warning title=Policy image-policy%3A signed::"))
+		})
+
+		It("should annotate the policy when Rode doesn't list any violations", func() {
+			policies.evaluations["image-policy"]["harbor.example.com/rode/app@sha256:123"] = &rode.EvaluatePolicyResponse{}
+			action := newPolicyAction()
+
+			Expect(action.evaluatePolicies(ctx, artifact)).NotTo(Succeed())
+			Expect(annotations.String()).To(Equal("::error title=Policy image-policy::harbor.example.com/rode/app@sha256:123 didn't pass the policy\n"))
+		})
+	})
+
+	It("should use POLICY_ACCESS_TOKEN instead of ACCESS_TOKEN when it's set", func() {
+		conf.AccessToken = ""
+		conf.Policy.AccessToken = "rode-token"
+		action := newPolicyAction()
+
+		Expect(action.evaluatePolicies(ctx, artifact)).To(Succeed())
+	})
+
+	It("should fail when a policy can't be evaluated", func() {
+		conf.AccessToken = "wrong-token"
+		action := newPolicyAction()
+
+		err := action.evaluatePolicies(ctx, artifact)
+
+		Expect(err).To(MatchError(ContainSubstring("error evaluating policy image-policy for harbor.example.com/rode/app@sha256:123: rpc error: code = Unauthenticated")))
+		Expect(action.outputs).NotTo(HaveKey("policyPassed"))
+	})
+
+	Describe("after recording the build", func() {
+		var buildCollector *fakeCollector
+
+		BeforeEach(func() {
+			buildCollector = &fakeCollector{id: "build-1", token: "rode-token"}
+			buildCollector.start(false)
+			conf.BuildCollector = &buildCollectorConfig{Host: buildCollector.host, Insecure: true}
+			conf.Signing = &signingConfig{}
+			conf.Tracing = &tracingConfig{}
+			policies.evaluations["image-policy"] = map[string]*rode.EvaluatePolicyResponse{
+				"harbor.example.com/rode/app@sha256:123": violation,
+			}
+		})
+
+		AfterEach(func() {
+			buildCollector.stop()
+		})
+
+		It("should evaluate the configured artifact, and still return the occurrence id", func() {
+			action, closeConns, err := newAction(ctx, conf)
+			Expect(err).NotTo(HaveOccurred())
+			defer closeConns()
+			action.annotations = annotations
+
+			id, err := action.Replay(ctx, &collector.CreateBuildRequest{Artifacts: []*collector.Artifact{
+				artifact,
+				{Id: "sbom.json@sha256:456", Names: []string{"sbom.json"}},
+				{Id: "build.intoto.json@sha256:789", Names: []string{"build.intoto.json"}},
+			}})
+
+			Expect(id).To(Equal("build-1"))
+			Expect(err).To(MatchError(ContainSubstring("didn't pass policy")))
+			Expect(policies.requests).To(HaveLen(2))
+			Expect(action.outputs["policyResults"]).NotTo(ContainSubstring("sbom.json"))
+			Expect(action.outputs["policyResults"]).NotTo(ContainSubstring("build.intoto.json"))
+		})
+
+		It("should name the Rode API when it can't connect", func() {
+			rodeServer.Stop()
+
+			_, _, err := newAction(ctx, conf)
+
+			Expect(err).To(MatchError(HavePrefix("unable to connect to the Rode API: ")))
+		})
+	})
+})
@@ -0,0 +1,237 @@
+// Copyright 2021 The Rode Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"time"
+
+	collector "github.com/rode/collector-build/proto/v1alpha1"
+)
+
+const (
+	slsaProvenanceV02 = "v0.2"
+	slsaProvenanceV1  = "v1"
+
+	inTotoStatementV01Type = "https://in-toto.io/Statement/v0.1"
+	inTotoStatementV1Type  = "https://in-toto.io/Statement/v1"
+
+	slsaProvenanceV02PredicateType = "https://slsa.dev/provenance/v0.2"
+	slsaProvenanceV1PredicateType  = "https://slsa.dev/provenance/v1"
+)
+
+type inTotoStatement struct {
+	Type          string          `json:"_type"`
+	Subject       []inTotoSubject `json:"subject"`
+	PredicateType string          `json:"predicateType"`
+	Predicate     interface{}     `json:"predicate"`
+}
+
+type inTotoSubject struct {
+	Name   string            `json:"name"`
+	Digest map[string]string `json:"digest"`
+}
+
+type slsaBuilder struct {
+	Id string `json:"id"`
+}
+
+type slsaMaterial struct {
+	Uri    string            `json:"uri"`
+	Digest map[string]string `json:"digest,omitempty"`
+}
+
+type slsaProvenanceV02Predicate struct {
+	Builder    slsaBuilder     `json:"builder"`
+	BuildType  string          `json:"buildType"`
+	Invocation slsaInvocation  `json:"invocation"`
+	Metadata   slsaMetadataV02 `json:"metadata"`
+	Materials  []slsaMaterial  `json:"materials"`
+}
+
+type slsaInvocation struct {
+	ConfigSource slsaConfigSource       `json:"configSource"`
+	Parameters   map[string]interface{} `json:"parameters,omitempty"`
+	Environment  map[string]interface{} `json:"environment"`
+}
+
+type slsaConfigSource struct {
+	Uri        string            `json:"uri"`
+	Digest     map[string]string `json:"digest"`
+	EntryPoint string            `json:"entryPoint"`
+}
+
+type slsaMetadataV02 struct {
+	BuildInvocationId string           `json:"buildInvocationId"`
+	BuildStartedOn    *time.Time       `json:"buildStartedOn,omitempty"`
+	BuildFinishedOn   *time.Time       `json:"buildFinishedOn,omitempty"`
+	Completeness      slsaCompleteness `json:"completeness"`
+	Reproducible      bool             `json:"reproducible"`
+}
+
+type slsaCompleteness struct {
+	Parameters  bool `json:"parameters"`
+	Environment bool `json:"environment"`
+	Materials   bool `json:"materials"`
+}
+
+type slsaProvenanceV1Predicate struct {
+	BuildDefinition slsaBuildDefinition `json:"buildDefinition"`
+	RunDetails      slsaRunDetails      `json:"runDetails"`
+}
+
+type slsaBuildDefinition struct {
+	BuildType            string                 `json:"buildType"`
+	ExternalParameters   map[string]interface{} `json:"externalParameters"`
+	InternalParameters   map[string]interface{} `json:"internalParameters,omitempty"`
+	ResolvedDependencies []slsaMaterial         `json:"resolvedDependencies,omitempty"`
+}
+
+type slsaRunDetails struct {
+	Builder  slsaBuilder    `json:"builder"`
+	Metadata slsaMetadataV1 `json:"metadata"`
+}
+
+type slsaMetadataV1 struct {
+	InvocationId string     `json:"invocationId"`
+	StartedOn    *time.Time `json:"startedOn,omitempty"`
+	FinishedOn   *time.Time `json:"finishedOn,omitempty"`
+}
+
+// newProvenanceStatement describes how the artifacts in the request were built, using the SLSA provenance format
+func newProvenanceStatement(version string, request *collector.CreateBuildRequest, invocation *buildInvocation) (*inTotoStatement, error) {
+	repoUri := request.Repository
+	startedOn := optionalTime(request.BuildStart.AsTime())
+	finishedOn := optionalTime(request.BuildEnd.AsTime())
+	source := slsaMaterial{
+		Uri: "git+" + repoUri,
+		Digest: map[string]string{
+			"sha1": request.CommitId,
+		},
+	}
+
+	statement := &inTotoStatement{
+		Subject: provenanceSubjects(request.Artifacts),
+	}
+
+	switch version {
+	case slsaProvenanceV02:
+		statement.Type = inTotoStatementV01Type
+		statement.PredicateType = slsaProvenanceV02PredicateType
+		statement.Predicate = &slsaProvenanceV02Predicate{
+			Builder:   slsaBuilder{Id: invocation.BuilderId},
+			BuildType: invocation.BuildTypeV02,
+			Invocation: slsaInvocation{
+				ConfigSource: slsaConfigSource{
+					Uri:        source.Uri,
+					Digest:     source.Digest,
+					EntryPoint: invocation.EntryPoint,
+				},
+				Environment: invocation.Environment,
+			},
+			Metadata: slsaMetadataV02{
+				BuildInvocationId: invocation.Id,
+				BuildStartedOn:    startedOn,
+				BuildFinishedOn:   finishedOn,
+				Completeness: slsaCompleteness{
+					Environment: true,
+				},
+			},
+			Materials: []slsaMaterial{source},
+		}
+	case slsaProvenanceV1:
+		workflow := map[string]string{
+			"repository": repoUri,
+			"path":       invocation.EntryPoint,
+		}
+		if invocation.EntryPointRef != "" {
+			workflow["ref"] = invocation.EntryPointRef
+		}
+
+		statement.Type = inTotoStatementV1Type
+		statement.PredicateType = slsaProvenanceV1PredicateType
+		statement.Predicate = &slsaProvenanceV1Predicate{
+			BuildDefinition: slsaBuildDefinition{
+				BuildType: invocation.BuildType,
+				ExternalParameters: map[string]interface{}{
+					"workflow": workflow,
+				},
+				InternalParameters: map[string]interface{}{
+					invocation.Provider: invocation.Environment,
+				},
+				ResolvedDependencies: []slsaMaterial{source},
+			},
+			RunDetails: slsaRunDetails{
+				Builder: slsaBuilder{Id: invocation.BuilderId},
+				Metadata: slsaMetadataV1{
+					InvocationId: invocation.Id,
+					StartedOn:    startedOn,
+					FinishedOn:   finishedOn,
+				},
+			},
+		}
+	default:
+		return nil, fmt.Errorf("unsupported provenance version %q, expected %s or %s", version, slsaProvenanceV02, slsaProvenanceV1)
+	}
+
+	return statement, nil
+}
+
+// provenanceSubjects lists the artifacts that can be identified by digest, e.g. harbor.example.com/rode/app@sha256:123
+func provenanceSubjects(artifacts []*collector.Artifact) []inTotoSubject {
+	subjects := []inTotoSubject{}
+	for _, artifact := range artifacts {
+		i := strings.LastIndex(artifact.Id, "@sha256:")
+		if i == -1 {
+			continue
+		}
+
+		subjects = append(subjects, inTotoSubject{
+			Name: artifact.Id[:i],
+			Digest: map[string]string{
+				"sha256": artifact.Id[i+len("@sha256:"):],
+			},
+		})
+	}
+
+	return subjects
+}
+
+func optionalTime(t time.Time) *time.Time {
+	if t.IsZero() || t.Unix() == 0 {
+		return nil
+	}
+	t = t.UTC()
+
+	return &t
+}
+
+func sha256Digest(contents []byte) string {
+	sum := sha256.Sum256(contents)
+
+	return hex.EncodeToString(sum[:])
+}
+
+// fileArtifact identifies a document produced by the action by its file name and content digest
+func fileArtifact(path, digest string) *collector.Artifact {
+	return &collector.Artifact{
+		Id:    fmt.Sprintf("%s@sha256:%s", filepath.Base(path), digest),
+		Names: []string{filepath.ToSlash(path)},
+	}
+}
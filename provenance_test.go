@@ -0,0 +1,166 @@
+// Copyright 2021 The Rode Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/google/go-github/v35/github"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	collector "github.com/rode/collector-build/proto/v1alpha1"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+var _ = Describe("provenance", func() {
+	var (
+		provider   *githubProvider
+		version    string
+		request    *collector.CreateBuildRequest
+		job        *github.WorkflowJob
+		buildStart time.Time
+		buildEnd   time.Time
+		digest     string
+
+		actualStatement *inTotoStatement
+		actualError     error
+	)
+
+	BeforeEach(func() {
+		buildStart = time.Now().UTC().Add(-time.Minute).Truncate(time.Second)
+		buildEnd = time.Now().UTC().Truncate(time.Second)
+		digest = fake.LetterN(64)
+
+		version = slsaProvenanceV02
+		provider = &githubProvider{
+			config: &githubConfig{
+				Actor:      fake.Username(),
+				JobId:      "build",
+				RunAttempt: "2",
+				RunId:      1234,
+				RunNumber:  "56",
+				Workflow:   "release",
+			},
+			runner: &runnerConfig{
+				Arch:        "X64",
+				Environment: "github-hosted",
+				Name:        "GitHub Actions 2",
+				OS:          "Linux",
+			},
+		}
+		request = &collector.CreateBuildRequest{
+			Artifacts: []*collector.Artifact{
+				{Id: "harbor.example.com/rode/app@sha256:" + digest},
+				{Id: "pkg:npm/app@1.0.0"},
+			},
+			BuildStart: timestamppb.New(buildStart),
+			BuildEnd:   timestamppb.New(buildEnd),
+			CommitId:   "foobar",
+			Repository: "https://github.com/rode/demo-app",
+		}
+		job = &github.WorkflowJob{
+			ID: github.Int64(789),
+		}
+	})
+
+	JustBeforeEach(func() {
+		actualStatement, actualError = newProvenanceStatement(version, request, provider.invocation(request.Repository, job))
+	})
+
+	It("should use artifacts with digests as subjects", func() {
+		Expect(actualError).NotTo(HaveOccurred())
+		Expect(actualStatement.Subject).To(ConsistOf(inTotoSubject{
+			Name:   "harbor.example.com/rode/app",
+			Digest: map[string]string{"sha256": digest},
+		}))
+	})
+
+	When("the version is v0.2", func() {
+		It("should return a v0.2 provenance predicate", func() {
+			Expect(actualStatement.Type).To(Equal("https://in-toto.io/Statement/v0.1"))
+			Expect(actualStatement.PredicateType).To(Equal("https://slsa.dev/provenance/v0.2"))
+			Expect(actualStatement.Predicate).To(BeAssignableToTypeOf(&slsaProvenanceV02Predicate{}))
+		})
+
+		It("should describe the workflow run", func() {
+			predicate := actualStatement.Predicate.(*slsaProvenanceV02Predicate)
+
+			Expect(predicate.Builder.Id).To(Equal(githubHostedBuilderId))
+			Expect(predicate.Invocation.ConfigSource.EntryPoint).To(Equal("release"))
+			Expect(predicate.Invocation.Environment).To(HaveKeyWithValue("github_job_id", "789"))
+			Expect(predicate.Invocation.Environment).To(HaveKeyWithValue("runner_os", "Linux"))
+			Expect(predicate.Invocation.Environment).To(HaveKeyWithValue("github_workflow", "release"))
+			Expect(predicate.Invocation.Environment).To(HaveKeyWithValue("github_triggering_actor", provider.config.Actor))
+			Expect(predicate.Metadata.BuildInvocationId).To(Equal("https://github.com/rode/demo-app/actions/runs/1234/attempts/2"))
+			Expect(*predicate.Metadata.BuildStartedOn).To(Equal(buildStart))
+			Expect(*predicate.Metadata.BuildFinishedOn).To(Equal(buildEnd))
+		})
+
+		It("should include the source commit as a material", func() {
+			predicate := actualStatement.Predicate.(*slsaProvenanceV02Predicate)
+
+			Expect(predicate.Materials).To(ConsistOf(slsaMaterial{
+				Uri:    "git+https://github.com/rode/demo-app",
+				Digest: map[string]string{"sha1": "foobar"},
+			}))
+		})
+	})
+
+	When("the version is v1", func() {
+		BeforeEach(func() {
+			version = slsaProvenanceV1
+			provider.runner.Environment = "self-hosted"
+		})
+
+		It("should return a v1 provenance predicate", func() {
+			Expect(actualStatement.Type).To(Equal("https://in-toto.io/Statement/v1"))
+			Expect(actualStatement.PredicateType).To(Equal("https://slsa.dev/provenance/v1"))
+
+			predicate := actualStatement.Predicate.(*slsaProvenanceV1Predicate)
+			Expect(predicate.BuildDefinition.ResolvedDependencies).To(HaveLen(1))
+			Expect(predicate.RunDetails.Builder.Id).To(Equal(selfHostedBuilderId))
+			Expect(predicate.RunDetails.Metadata.InvocationId).To(Equal(fmt.Sprintf("%s/actions/runs/1234/attempts/2", request.Repository)))
+		})
+
+		When("the workflow ref is known", func() {
+			BeforeEach(func() {
+				provider.config.RepoSlug = "rode/demo-app"
+				provider.config.WorkflowRef = "rode/demo-app/.github/workflows/release.yml@refs/tags/v1.2.3"
+			})
+
+			It("should use the workflow file and ref as external parameters", func() {
+				predicate := actualStatement.Predicate.(*slsaProvenanceV1Predicate)
+
+				Expect(predicate.BuildDefinition.ExternalParameters).To(HaveKeyWithValue("workflow", map[string]string{
+					"repository": "https://github.com/rode/demo-app",
+					"path":       ".github/workflows/release.yml",
+					"ref":        "refs/tags/v1.2.3",
+				}))
+			})
+		})
+	})
+
+	When("the version is not supported", func() {
+		BeforeEach(func() {
+			version = "v3"
+		})
+
+		It("should return an error", func() {
+			Expect(actualError).To(HaveOccurred())
+			Expect(actualError.Error()).To(ContainSubstring("unsupported provenance version"))
+		})
+	})
+})
@@ -0,0 +1,168 @@
+// Copyright 2021 The Rode Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/sethvargo/go-envconfig"
+)
+
+const (
+	providerGitHub  = "github"
+	providerGitLab  = "gitlab"
+	providerJenkins = "jenkins"
+)
+
+// ciProvider supplies the details of the build from the CI system that's running the action
+type ciProvider interface {
+	BuildMetadata(ctx context.Context) (*buildMetadata, error)
+}
+
+// buildMetadata is everything needed from the CI system to assemble a CreateBuildRequest
+type buildMetadata struct {
+	Actor        string
+	BuildEnd     time.Time
+	BuildStart   time.Time
+	CommitId     string
+	CommitUri    string
+	LogsUri      string
+	ProvenanceId string
+	Repository   string
+	StepSummary  string
+	Invocation   *buildInvocation
+	// PullRequest is only set when the build ran for a pull request
+	PullRequest *buildPullRequest
+	// Trigger is only set by CI systems that report why the build ran
+	Trigger *buildTrigger
+	// Verification is only set by CI systems that can check the signature of the commit
+	Verification *commitVerification
+	// Warnings are problems the CI system ran into that don't stop the build from being recorded, the action logs them
+	Warnings []string
+}
+
+// commitVerification is whether the commit has a valid signature, the reason is set by the CI system, e.g. unsigned or valid
+type commitVerification struct {
+	Verified bool
+	Reason   string
+	Signer   string
+}
+
+// buildTrigger describes the event that started the build, and the workflow that it ran
+type buildTrigger struct {
+	Event           string
+	Ref             string
+	TriggeringActor string
+	Workflow        string
+	WorkflowRef     string
+}
+
+// buildPullRequest is the pull request that the build ran for, the head is its branch and the base is the branch it's merged into
+type buildPullRequest struct {
+	Number  int
+	Url     string
+	HeadRef string
+	HeadSha string
+	BaseRef string
+}
+
+// buildInvocation describes the CI run for the provenance statement
+type buildInvocation struct {
+	Id        string
+	Provider  string
+	BuilderId string
+	// BuildType is used for SLSA v1 provenance, older versions use BuildTypeV02
+	BuildType    string
+	BuildTypeV02 string
+	EntryPoint   string
+	// EntryPointRef is the ref that the entry point was read from, when it's known
+	EntryPointRef string
+	Environment   map[string]interface{}
+}
+
+// detectProvider picks the CI system based on the variables that each one sets, defaulting to GitHub Actions
+func detectProvider(l envconfig.Lookuper) string {
+	if value, ok := l.Lookup("GITLAB_CI"); ok && value == "true" {
+		return providerGitLab
+	}
+
+	if _, ok := l.Lookup("JENKINS_URL"); ok {
+		return providerJenkins
+	}
+
+	return providerGitHub
+}
+
+// newCIProvider loads the configuration for the selected CI system. This is separate from the main config so that
+// variables required by one provider aren't required when running on another.
+func newCIProvider(ctx context.Context, c *config, l envconfig.Lookuper) (ciProvider, error) {
+	name := c.Provider
+	if name == "" {
+		name = detectProvider(l)
+	}
+
+	switch name {
+	case providerGitHub:
+		githubConf := &githubConfig{}
+		if err := envconfig.ProcessWith(ctx, githubConf, envconfig.PrefixLookuper("GITHUB_", l)); err != nil {
+			return nil, err
+		}
+
+		runnerConf := &runnerConfig{}
+		if err := envconfig.ProcessWith(ctx, runnerConf, envconfig.PrefixLookuper("RUNNER_", l)); err != nil {
+			return nil, err
+		}
+
+		client := newGitHubClient(githubConf, c.Timeout.Rpc)
+
+		return &githubProvider{
+			actions:             client.Actions,
+			buildSteps:          splitList(c.BuildSteps, c.ArtifactNamesDelimiter),
+			commitSource:        c.CommitSource,
+			config:              githubConf,
+			creatorSource:       c.CreatorSource,
+			git:                 client.Git,
+			requireSignedCommit: c.RequireSignedCommit,
+			runner:              runnerConf,
+			users:               client.Users,
+			scope:               c.Scope,
+			workflowJobs:        splitList(c.WorkflowJobs, c.ArtifactNamesDelimiter),
+		}, nil
+	case providerGitLab:
+		gitlabConf := &gitlabConfig{}
+		if err := envconfig.ProcessWith(ctx, gitlabConf, l); err != nil {
+			return nil, err
+		}
+
+		return &gitlabProvider{
+			client: newHTTPClient(c.Timeout),
+			config: gitlabConf,
+		}, nil
+	case providerJenkins:
+		jenkinsConf := &jenkinsConfig{}
+		if err := envconfig.ProcessWith(ctx, jenkinsConf, l); err != nil {
+			return nil, err
+		}
+
+		return &jenkinsProvider{
+			client: newHTTPClient(c.Timeout),
+			config: jenkinsConf,
+		}, nil
+	}
+
+	return nil, fmt.Errorf("unsupported CI provider %q, expected one of %s, %s or %s", name, providerGitHub, providerGitLab, providerJenkins)
+}
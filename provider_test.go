@@ -0,0 +1,118 @@
+// Copyright 2021 The Rode Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/sethvargo/go-envconfig"
+)
+
+var _ = Describe("newCIProvider", func() {
+	var (
+		ctx  context.Context
+		conf *config
+		env  map[string]string
+
+		actualProvider ciProvider
+		actualError    error
+	)
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		conf = &config{Timeout: &timeoutConfig{}}
+		env = map[string]string{
+			"GITHUB_ACTOR":      fake.Username(),
+			"GITHUB_JOB":        "build",
+			"GITHUB_REPOSITORY": "rode/demo-app",
+			"GITHUB_RUN_ID":     "1234",
+			"GITHUB_SERVER_URL": "https://github.com",
+			"GITHUB_SHA":        "foobar",
+			"GITHUB_TOKEN":      fake.LetterN(10),
+			"RUNNER_OS":         "Linux",
+		}
+	})
+
+	JustBeforeEach(func() {
+		actualProvider, actualError = newCIProvider(ctx, conf, envconfig.MapLookuper(env))
+	})
+
+	It("should default to GitHub Actions", func() {
+		Expect(actualError).NotTo(HaveOccurred())
+		Expect(actualProvider).To(BeAssignableToTypeOf(&githubProvider{}))
+
+		provider := actualProvider.(*githubProvider)
+		Expect(provider.config.RunId).To(Equal(int64(1234)))
+		Expect(provider.runner.OS).To(Equal("Linux"))
+	})
+
+	When("running in GitLab CI", func() {
+		BeforeEach(func() {
+			env = map[string]string{
+				"GITLAB_CI":      "true",
+				"CI_API_V4_URL":  "https://gitlab.example.com/api/v4",
+				"CI_COMMIT_SHA":  "foobar",
+				"CI_JOB_ID":      "42",
+				"CI_JOB_TOKEN":   fake.LetterN(10),
+				"CI_JOB_URL":     "https://gitlab.example.com/rode/demo-app/-/jobs/42",
+				"CI_PROJECT_URL": "https://gitlab.example.com/rode/demo-app",
+			}
+		})
+
+		It("should detect the GitLab provider without requiring GitHub variables", func() {
+			Expect(actualError).NotTo(HaveOccurred())
+			Expect(actualProvider).To(BeAssignableToTypeOf(&gitlabProvider{}))
+			Expect(actualProvider.(*gitlabProvider).config.JobId).To(Equal(int64(42)))
+		})
+	})
+
+	When("running in Jenkins", func() {
+		BeforeEach(func() {
+			env = map[string]string{
+				"JENKINS_URL": "https://jenkins.example.com/",
+				"BUILD_URL":   "https://jenkins.example.com/job/demo-app/12/",
+				"GIT_COMMIT":  "foobar",
+				"GIT_URL":     "git@github.com:rode/demo-app.git",
+			}
+		})
+
+		It("should detect the Jenkins provider", func() {
+			Expect(actualError).NotTo(HaveOccurred())
+			Expect(actualProvider).To(BeAssignableToTypeOf(&jenkinsProvider{}))
+		})
+	})
+
+	When("a required variable for the provider is missing", func() {
+		BeforeEach(func() {
+			conf.Provider = providerGitLab
+		})
+
+		It("should return an error", func() {
+			Expect(actualError).To(HaveOccurred())
+		})
+	})
+
+	When("the provider is not supported", func() {
+		BeforeEach(func() {
+			conf.Provider = "circleci"
+		})
+
+		It("should return an error", func() {
+			Expect(actualError).To(MatchError(ContainSubstring("unsupported CI provider")))
+		})
+	})
+})
@@ -0,0 +1,280 @@
+// Copyright 2021 The Rode Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+const purlScheme = "pkg:"
+
+var (
+	purlTypeRegexp         = regexp.MustCompile(`^[a-z][a-z0-9.+-]*$`)
+	purlQualifierKeyRegexp = regexp.MustCompile(`^[a-z][a-z0-9._-]*$`)
+)
+
+// packageURL is a package identifier as described by the purl specification: https://github.com/package-url/purl-spec
+type packageURL struct {
+	Type       string
+	Namespace  string
+	Name       string
+	Version    string
+	Qualifiers map[string]string
+	Subpath    string
+}
+
+func isPackageURL(value string) bool {
+	return strings.HasPrefix(strings.ToLower(value), purlScheme)
+}
+
+func parsePackageURL(value string) (*packageURL, error) {
+	if !isPackageURL(value) {
+		return nil, fmt.Errorf("package url %q must start with %q", value, purlScheme)
+	}
+
+	p := &packageURL{}
+	remainder := strings.TrimLeft(value[len(purlScheme):], "/")
+
+	if i := strings.LastIndex(remainder, "#"); i != -1 {
+		var segments []string
+		for _, segment := range strings.Split(remainder[i+1:], "/") {
+			segment, err := url.PathUnescape(segment)
+			if err != nil {
+				return nil, fmt.Errorf("invalid subpath in package url %q: %s", value, err)
+			}
+			if segment == "" || segment == "." || segment == ".." {
+				continue
+			}
+			segments = append(segments, segment)
+		}
+		p.Subpath = strings.Join(segments, "/")
+		remainder = remainder[:i]
+	}
+
+	if i := strings.LastIndex(remainder, "?"); i != -1 {
+		qualifiers, err := parsePackageURLQualifiers(remainder[i+1:])
+		if err != nil {
+			return nil, fmt.Errorf("invalid qualifiers in package url %q: %s", value, err)
+		}
+		p.Qualifiers = qualifiers
+		remainder = remainder[:i]
+	}
+
+	remainder = strings.TrimRight(remainder, "/")
+	if i := strings.LastIndex(remainder, "@"); i != -1 && i > strings.LastIndex(remainder, "/") {
+		version, err := url.PathUnescape(remainder[i+1:])
+		if err != nil {
+			return nil, fmt.Errorf("invalid version in package url %q: %s", value, err)
+		}
+		p.Version = version
+		remainder = remainder[:i]
+	}
+
+	parts := strings.Split(remainder, "/")
+	if len(parts) < 2 {
+		return nil, fmt.Errorf("package url %q must contain a type and a name", value)
+	}
+
+	p.Type = parts[0]
+	name, err := url.PathUnescape(parts[len(parts)-1])
+	if err != nil {
+		return nil, fmt.Errorf("invalid name in package url %q: %s", value, err)
+	}
+	p.Name = name
+
+	var namespace []string
+	for _, segment := range parts[1 : len(parts)-1] {
+		segment, err := url.PathUnescape(segment)
+		if err != nil {
+			return nil, fmt.Errorf("invalid namespace in package url %q: %s", value, err)
+		}
+		if segment == "" {
+			continue
+		}
+		namespace = append(namespace, segment)
+	}
+	p.Namespace = strings.Join(namespace, "/")
+
+	if err := p.normalize(); err != nil {
+		return nil, err
+	}
+
+	return p, nil
+}
+
+func parsePackageURLQualifiers(raw string) (map[string]string, error) {
+	qualifiers := map[string]string{}
+	for _, pair := range strings.Split(raw, "&") {
+		if pair == "" {
+			continue
+		}
+
+		kv := strings.SplitN(pair, "=", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("qualifier %q must be in the form key=value", pair)
+		}
+
+		value, err := url.PathUnescape(kv[1])
+		if err != nil {
+			return nil, err
+		}
+		qualifiers[kv[0]] = value
+	}
+
+	return qualifiers, nil
+}
+
+// normalize applies the canonicalization rules from the purl specification, including the type-specific ones for
+// the package types we expect to see from publishing jobs, and then validates the result.
+func (p *packageURL) normalize() error {
+	p.Type = strings.ToLower(strings.TrimSpace(p.Type))
+	p.Namespace = strings.Trim(strings.TrimSpace(p.Namespace), "/")
+	p.Name = strings.Trim(strings.TrimSpace(p.Name), "/")
+	p.Version = strings.TrimSpace(p.Version)
+
+	switch p.Type {
+	case "bitbucket", "github", "golang", "npm":
+		p.Namespace = strings.ToLower(p.Namespace)
+		if p.Type != "golang" {
+			p.Name = strings.ToLower(p.Name)
+		}
+	case "pypi":
+		p.Name = strings.ReplaceAll(strings.ToLower(p.Name), "_", "-")
+	}
+
+	qualifiers := map[string]string{}
+	for key, value := range p.Qualifiers {
+		key = strings.ToLower(strings.TrimSpace(key))
+		value = strings.TrimSpace(value)
+		if value == "" {
+			continue
+		}
+		qualifiers[key] = value
+	}
+	p.Qualifiers = qualifiers
+
+	return p.validate()
+}
+
+func (p *packageURL) validate() error {
+	if !purlTypeRegexp.MatchString(p.Type) {
+		return fmt.Errorf("package url type %q is invalid", p.Type)
+	}
+
+	if p.Name == "" {
+		return fmt.Errorf("package url name is required")
+	}
+
+	if p.Type == "maven" && p.Namespace == "" {
+		return fmt.Errorf("maven package urls require a namespace (group id)")
+	}
+
+	for key := range p.Qualifiers {
+		if !purlQualifierKeyRegexp.MatchString(key) {
+			return fmt.Errorf("package url qualifier key %q is invalid", key)
+		}
+	}
+
+	return nil
+}
+
+// String returns the canonical form of the package url
+func (p *packageURL) String() string {
+	var builder strings.Builder
+	builder.WriteString(purlScheme)
+	builder.WriteString(p.Type)
+	builder.WriteString("/")
+
+	if p.Namespace != "" {
+		for _, segment := range strings.Split(p.Namespace, "/") {
+			builder.WriteString(escapePackageURLComponent(segment, ""))
+			builder.WriteString("/")
+		}
+	}
+
+	builder.WriteString(escapePackageURLComponent(p.Name, ""))
+
+	if p.Version != "" {
+		builder.WriteString("@")
+		builder.WriteString(escapePackageURLComponent(p.Version, ":"))
+	}
+
+	if len(p.Qualifiers) != 0 {
+		var keys []string
+		for key := range p.Qualifiers {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+
+		var pairs []string
+		for _, key := range keys {
+			pairs = append(pairs, key+"="+escapePackageURLComponent(p.Qualifiers[key], ":/"))
+		}
+
+		builder.WriteString("?")
+		builder.WriteString(strings.Join(pairs, "&"))
+	}
+
+	if p.Subpath != "" {
+		var segments []string
+		for _, segment := range strings.Split(p.Subpath, "/") {
+			segments = append(segments, escapePackageURLComponent(segment, ""))
+		}
+
+		builder.WriteString("#")
+		builder.WriteString(strings.Join(segments, "/"))
+	}
+
+	return builder.String()
+}
+
+// withVersion returns a copy of the package url that refers to a different version of the same package
+func (p *packageURL) withVersion(version string) *packageURL {
+	qualifiers := map[string]string{}
+	for key, value := range p.Qualifiers {
+		qualifiers[key] = value
+	}
+
+	return &packageURL{
+		Type:       p.Type,
+		Namespace:  p.Namespace,
+		Name:       p.Name,
+		Version:    strings.TrimSpace(version),
+		Qualifiers: qualifiers,
+		Subpath:    p.Subpath,
+	}
+}
+
+// escapePackageURLComponent percent-encodes everything outside of the unreserved character set, with the exception of
+// any characters in keep.
+func escapePackageURLComponent(value, keep string) string {
+	var builder strings.Builder
+	for _, b := range []byte(value) {
+		switch {
+		case 'a' <= b && b <= 'z', 'A' <= b && b <= 'Z', '0' <= b && b <= '9', strings.IndexByte("-._~", b) != -1:
+			builder.WriteByte(b)
+		case strings.IndexByte(keep, b) != -1:
+			builder.WriteByte(b)
+		default:
+			builder.WriteString(fmt.Sprintf("%%%02X", b))
+		}
+	}
+
+	return builder.String()
+}
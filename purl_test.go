@@ -0,0 +1,66 @@
+// Copyright 2021 The Rode Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("packageURL", func() {
+	DescribeTable("parsing and canonicalization",
+		func(input, expected string) {
+			purl, err := parsePackageURL(input)
+
+			Expect(err).NotTo(HaveOccurred())
+			Expect(purl.String()).To(Equal(expected))
+		},
+		Entry("npm with scope", "pkg:npm/%40Angular/Animation@12.3.1", "pkg:npm/%40angular/animation@12.3.1"),
+		Entry("maven with qualifiers", "pkg:maven/org.apache.xmlgraphics/batik-anim@1.9.1?Type=pom&classifier=", "pkg:maven/org.apache.xmlgraphics/batik-anim@1.9.1?type=pom"),
+		Entry("pypi name", "pkg:PYPI/Django_Package@1.11.1", "pkg:pypi/django-package@1.11.1"),
+		Entry("qualifier ordering", "pkg:maven/org.example/app@1.0.0?type=jar&classifier=sources", "pkg:maven/org.example/app@1.0.0?classifier=sources&type=jar"),
+		Entry("golang module with subpath", "pkg:golang/github.com/Rode/collector-build@v0.3.0#proto/./v1alpha1/", "pkg:golang/github.com/rode/collector-build@v0.3.0#proto/v1alpha1"),
+		Entry("qualifier value with url", "pkg:maven/org.example/app@1.0.0?repository_url=repo.example.com/release", "pkg:maven/org.example/app@1.0.0?repository_url=repo.example.com/release"),
+		Entry("extra slashes", "pkg://generic//foo/bar/", "pkg:generic/foo/bar"),
+	)
+
+	DescribeTable("invalid package urls",
+		func(input string) {
+			_, err := parsePackageURL(input)
+
+			Expect(err).To(HaveOccurred())
+		},
+		Entry("missing scheme", "npm/foo@1.0.0"),
+		Entry("missing name", "pkg:npm"),
+		Entry("invalid type", "pkg:1npm/foo@1.0.0"),
+		Entry("maven without namespace", "pkg:maven/foo@1.0.0"),
+		Entry("malformed qualifier", "pkg:npm/foo@1.0.0?bar"),
+		Entry("invalid qualifier key", "pkg:npm/foo@1.0.0?1bar=baz"),
+	)
+
+	Describe("withVersion", func() {
+		It("should copy the package url with the new version", func() {
+			purl, err := parsePackageURL("pkg:npm/foo@1.0.0?arch=x86")
+			Expect(err).NotTo(HaveOccurred())
+
+			alternate := purl.withVersion("latest")
+			alternate.Qualifiers["arch"] = "arm"
+
+			Expect(alternate.String()).To(Equal("pkg:npm/foo@latest?arch=arm"))
+			Expect(purl.String()).To(Equal("pkg:npm/foo@1.0.0?arch=x86"))
+		})
+	})
+})
@@ -0,0 +1,388 @@
+// Copyright 2021 The Rode Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	dockerHubRegistry    = "docker.io"
+	dockerHubAPIHost     = "registry-1.docker.io"
+	dockerHubCredsKey    = "https://index.docker.io/v1/"
+	defaultImageTag      = "latest"
+	contentDigestHeader  = "Docker-Content-Digest"
+	authenticateHeader   = "WWW-Authenticate"
+	dockerConfigFileName = "config.json"
+)
+
+var manifestMediaTypes = []string{
+	"application/vnd.oci.image.index.v1+json",
+	"application/vnd.oci.image.manifest.v1+json",
+	"application/vnd.docker.distribution.manifest.list.v2+json",
+	"application/vnd.docker.distribution.manifest.v2+json",
+}
+
+//go:generate counterfeiter -o mocks/digest_resolver.go . digestResolver
+type digestResolver interface {
+	ResolveDigest(ctx context.Context, reference string) (string, error)
+}
+
+// imageReference is a parsed container image reference, e.g. harbor.example.com/rode/app:v1.2.3
+type imageReference struct {
+	// Name is the image name as it was written, without the tag or digest
+	Name       string
+	Registry   string
+	Repository string
+	Tag        string
+	Digest     string
+}
+
+func parseImageReference(reference string) (*imageReference, error) {
+	ref := &imageReference{}
+	name := strings.TrimSpace(reference)
+	if name == "" || strings.Contains(name, "://") {
+		return nil, fmt.Errorf("%q is not a valid image reference", reference)
+	}
+
+	if i := strings.Index(name, "@"); i != -1 {
+		ref.Digest = name[i+1:]
+		name = name[:i]
+	}
+
+	if i := strings.LastIndex(name, ":"); i != -1 && i > strings.LastIndex(name, "/") {
+		ref.Tag = name[i+1:]
+		name = name[:i]
+	}
+
+	if name == "" || strings.HasSuffix(name, "/") {
+		return nil, fmt.Errorf("%q is not a valid image reference", reference)
+	}
+	ref.Name = name
+
+	parts := strings.SplitN(name, "/", 2)
+	if len(parts) == 2 && (strings.ContainsAny(parts[0], ".:") || parts[0] == "localhost") {
+		ref.Registry = parts[0]
+		ref.Repository = parts[1]
+	} else {
+		ref.Registry = dockerHubRegistry
+		ref.Repository = name
+	}
+
+	if ref.Registry == dockerHubRegistry && !strings.Contains(ref.Repository, "/") {
+		ref.Repository = "library/" + ref.Repository
+	}
+
+	if ref.Tag == "" && ref.Digest == "" {
+		ref.Tag = defaultImageTag
+	}
+
+	return ref, nil
+}
+
+type registryCredential struct {
+	username string
+	password string
+}
+
+type registryClient struct {
+	httpClient  *http.Client
+	credentials map[string]registryCredential
+	insecure    bool
+}
+
+type dockerConfigFile struct {
+	Auths map[string]struct {
+		Auth     string `json:"auth"`
+		Username string `json:"username"`
+		Password string `json:"password"`
+	} `json:"auths"`
+}
+
+func newRegistryClient(httpClient *http.Client, c *registryConfig) (*registryClient, error) {
+	client := &registryClient{
+		httpClient:  httpClient,
+		credentials: map[string]registryCredential{},
+		insecure:    c.Insecure,
+	}
+
+	if err := client.loadDockerConfig(c.DockerConfig); err != nil {
+		return nil, err
+	}
+
+	if c.Username != "" {
+		client.credentials[""] = registryCredential{
+			username: c.Username,
+			password: c.Password,
+		}
+	}
+
+	return client, nil
+}
+
+// loadDockerConfig reads credentials from the auths section of a Docker config.json; credential helpers are not supported.
+// Without a directory, it's the standard DOCKER_CONFIG, then ~/.docker. In the action's container HOME is /github/home,
+// so the runner's ~/.docker isn't there.
+func (r *registryClient) loadDockerConfig(dir string) error {
+	if dir == "" {
+		dir = os.Getenv("DOCKER_CONFIG")
+	}
+	if dir == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return nil
+		}
+		dir = filepath.Join(home, ".docker")
+	}
+
+	contents, err := os.ReadFile(filepath.Join(dir, dockerConfigFileName))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("error reading docker config: %s", err)
+	}
+
+	dockerConfig := &dockerConfigFile{}
+	if err := json.Unmarshal(contents, dockerConfig); err != nil {
+		return fmt.Errorf("error parsing docker config: %s", err)
+	}
+
+	for host, auth := range dockerConfig.Auths {
+		credential := registryCredential{
+			username: auth.Username,
+			password: auth.Password,
+		}
+
+		if auth.Auth != "" {
+			decoded, err := base64.StdEncoding.DecodeString(auth.Auth)
+			if err != nil {
+				return fmt.Errorf("error decoding docker credentials for %s: %s", host, err)
+			}
+
+			parts := strings.SplitN(string(decoded), ":", 2)
+			if len(parts) != 2 {
+				return fmt.Errorf("docker credentials for %s are malformed", host)
+			}
+			credential.username, credential.password = parts[0], parts[1]
+		}
+
+		r.credentials[normalizeRegistryHost(host)] = credential
+	}
+
+	return nil
+}
+
+func normalizeRegistryHost(host string) string {
+	if host == dockerHubCredsKey {
+		return dockerHubRegistry
+	}
+
+	host = strings.TrimPrefix(strings.TrimPrefix(host, "https://"), "http://")
+
+	return strings.SplitN(host, "/", 2)[0]
+}
+
+func (r *registryClient) credentialFor(registry string) (registryCredential, bool) {
+	if credential, ok := r.credentials[""]; ok {
+		return credential, true
+	}
+
+	credential, ok := r.credentials[registry]
+
+	return credential, ok
+}
+
+// ResolveDigest issues a HEAD request for the manifest referenced by the image tag and returns the content digest
+func (r *registryClient) ResolveDigest(ctx context.Context, image string) (string, error) {
+	reference, err := parseImageReference(image)
+	if err != nil {
+		return "", err
+	}
+
+	host := reference.Registry
+	if host == dockerHubRegistry {
+		host = dockerHubAPIHost
+	}
+
+	scheme := "https"
+	if r.insecure {
+		scheme = "http"
+	}
+	manifestUrl := fmt.Sprintf("%s://%s/v2/%s/manifests/%s", scheme, host, reference.Repository, reference.Tag)
+
+	response, err := r.headManifest(ctx, manifestUrl, "")
+	if err != nil {
+		return "", err
+	}
+
+	if response.StatusCode == http.StatusUnauthorized {
+		authorization, err := r.authorize(ctx, reference, response.Header.Get(authenticateHeader))
+		if err != nil {
+			return "", err
+		}
+
+		response, err = r.headManifest(ctx, manifestUrl, authorization)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	if response.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("unexpected status %d fetching manifest for %s:%s", response.StatusCode, reference.Name, reference.Tag)
+	}
+
+	digest := response.Header.Get(contentDigestHeader)
+	if !strings.HasPrefix(digest, "sha256:") {
+		return "", fmt.Errorf("registry did not return a sha256 digest for %s:%s", reference.Name, reference.Tag)
+	}
+
+	return digest, nil
+}
+
+func (r *registryClient) headManifest(ctx context.Context, manifestUrl, authorization string) (*http.Response, error) {
+	request, err := http.NewRequestWithContext(ctx, http.MethodHead, manifestUrl, nil)
+	if err != nil {
+		return nil, err
+	}
+	request.Header.Set("Accept", strings.Join(manifestMediaTypes, ", "))
+	if authorization != "" {
+		request.Header.Set("Authorization", authorization)
+	}
+
+	response, err := r.httpClient.Do(request)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching manifest: %s", err)
+	}
+	response.Body.Close()
+
+	return response, nil
+}
+
+// authorize answers the registry's authentication challenge, either with basic auth or by exchanging credentials for a bearer token
+func (r *registryClient) authorize(ctx context.Context, reference *imageReference, challenge string) (string, error) {
+	credential, hasCredential := r.credentialFor(reference.Registry)
+	scheme, params := parseAuthenticateChallenge(challenge)
+
+	switch strings.ToLower(scheme) {
+	case "basic":
+		if !hasCredential {
+			return "", fmt.Errorf("registry %s requires credentials", reference.Registry)
+		}
+
+		return "Basic " + basicAuth(credential), nil
+	case "bearer":
+		tokenUrl, err := url.Parse(params["realm"])
+		if err != nil || params["realm"] == "" {
+			return "", fmt.Errorf("registry %s returned an invalid token realm", reference.Registry)
+		}
+
+		query := tokenUrl.Query()
+		if params["service"] != "" {
+			query.Set("service", params["service"])
+		}
+		scope := params["scope"]
+		if scope == "" {
+			scope = fmt.Sprintf("repository:%s:pull", reference.Repository)
+		}
+		query.Set("scope", scope)
+		tokenUrl.RawQuery = query.Encode()
+
+		request, err := http.NewRequestWithContext(ctx, http.MethodGet, tokenUrl.String(), nil)
+		if err != nil {
+			return "", err
+		}
+		if hasCredential {
+			request.SetBasicAuth(credential.username, credential.password)
+		}
+
+		response, err := r.httpClient.Do(request)
+		if err != nil {
+			return "", fmt.Errorf("error fetching registry token: %s", err)
+		}
+		defer response.Body.Close()
+
+		if response.StatusCode != http.StatusOK {
+			return "", fmt.Errorf("unexpected status %d fetching registry token", response.StatusCode)
+		}
+
+		token := &struct {
+			Token       string `json:"token"`
+			AccessToken string `json:"access_token"`
+		}{}
+		if err := json.NewDecoder(response.Body).Decode(token); err != nil {
+			return "", fmt.Errorf("error decoding registry token: %s", err)
+		}
+
+		if token.Token == "" {
+			token.Token = token.AccessToken
+		}
+
+		return "Bearer " + token.Token, nil
+	}
+
+	return "", fmt.Errorf("registry %s returned an unsupported authentication challenge %q", reference.Registry, challenge)
+}
+
+func basicAuth(credential registryCredential) string {
+	return base64.StdEncoding.EncodeToString([]byte(credential.username + ":" + credential.password))
+}
+
+// parseAuthenticateChallenge splits a WWW-Authenticate header like `Bearer realm="https://auth",service="registry"`
+// into the scheme and its parameters
+func parseAuthenticateChallenge(header string) (string, map[string]string) {
+	params := map[string]string{}
+	parts := strings.SplitN(strings.TrimSpace(header), " ", 2)
+	if len(parts) != 2 {
+		return parts[0], params
+	}
+
+	remainder := parts[1]
+	for remainder != "" {
+		eq := strings.Index(remainder, "=")
+		if eq == -1 {
+			break
+		}
+		key := strings.ToLower(strings.TrimSpace(remainder[:eq]))
+		remainder = strings.TrimSpace(remainder[eq+1:])
+
+		var value string
+		if strings.HasPrefix(remainder, `"`) {
+			end := strings.Index(remainder[1:], `"`)
+			if end == -1 {
+				value, remainder = remainder[1:], ""
+			} else {
+				value, remainder = remainder[1:end+1], remainder[end+2:]
+			}
+		} else if comma := strings.Index(remainder, ","); comma != -1 {
+			value, remainder = remainder[:comma], remainder[comma:]
+		} else {
+			value, remainder = remainder, ""
+		}
+
+		params[key] = value
+		remainder = strings.TrimPrefix(strings.TrimSpace(remainder), ",")
+	}
+
+	return parts[0], params
+}
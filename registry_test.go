@@ -0,0 +1,297 @@
+// Copyright 2021 The Rode Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("registry", func() {
+	DescribeTable("parseImageReference",
+		func(input string, expected *imageReference) {
+			actual, err := parseImageReference(input)
+
+			Expect(err).NotTo(HaveOccurred())
+			Expect(actual).To(Equal(expected))
+		},
+		Entry("private registry", "harbor.example.com/rode/app:v1.2.3", &imageReference{
+			Name:       "harbor.example.com/rode/app",
+			Registry:   "harbor.example.com",
+			Repository: "rode/app",
+			Tag:        "v1.2.3",
+		}),
+		Entry("registry with port", "localhost:5000/app:1", &imageReference{
+			Name:       "localhost:5000/app",
+			Registry:   "localhost:5000",
+			Repository: "app",
+			Tag:        "1",
+		}),
+		Entry("docker hub official image", "alpine", &imageReference{
+			Name:       "alpine",
+			Registry:   "docker.io",
+			Repository: "library/alpine",
+			Tag:        "latest",
+		}),
+		Entry("docker hub image", "rode/app:v1", &imageReference{
+			Name:       "rode/app",
+			Registry:   "docker.io",
+			Repository: "rode/app",
+			Tag:        "v1",
+		}),
+		Entry("digest", "ghcr.io/rode/app@sha256:abc", &imageReference{
+			Name:       "ghcr.io/rode/app",
+			Registry:   "ghcr.io",
+			Repository: "rode/app",
+			Digest:     "sha256:abc",
+		}),
+	)
+
+	DescribeTable("parseImageReference errors",
+		func(input string) {
+			_, err := parseImageReference(input)
+
+			Expect(err).To(HaveOccurred())
+		},
+		Entry("empty", ""),
+		Entry("url", "https://example.com/foo"),
+		Entry("only a tag", ":v1"),
+	)
+
+	Describe("registryClient", func() {
+		var (
+			ctx               context.Context
+			server            *httptest.Server
+			registryHost      string
+			expectedDigest    string
+			conf              *registryConfig
+			requests          []*http.Request
+			handler           http.HandlerFunc
+			writeDockerConfig func(host string)
+
+			actualDigest string
+			actualError  error
+		)
+
+		manifestHandler := func(authorization string) http.HandlerFunc {
+			return func(w http.ResponseWriter, r *http.Request) {
+				if r.Method != http.MethodHead || r.URL.Path != "/v2/rode/app/manifests/v1.2.3" {
+					w.WriteHeader(http.StatusNotFound)
+					return
+				}
+
+				if authorization != "" && r.Header.Get("Authorization") != authorization {
+					w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer realm="https://%s/token",service="fake-registry",scope="repository:rode/app:pull"`, r.Host))
+					w.WriteHeader(http.StatusUnauthorized)
+					return
+				}
+
+				w.Header().Set("Docker-Content-Digest", expectedDigest)
+				w.WriteHeader(http.StatusOK)
+			}
+		}
+
+		BeforeEach(func() {
+			ctx = context.Background()
+			requests = nil
+			expectedDigest = "sha256:" + fake.LetterN(64)
+			conf = &registryConfig{
+				DockerConfig: tempDir(),
+			}
+			handler = manifestHandler("")
+			writeDockerConfig = nil
+		})
+
+		JustBeforeEach(func() {
+			server = httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				requests = append(requests, r)
+				handler(w, r)
+			}))
+			registryHost = strings.TrimPrefix(server.URL, "https://")
+			if writeDockerConfig != nil {
+				writeDockerConfig(registryHost)
+			}
+
+			client, err := newRegistryClient(server.Client(), conf)
+			Expect(err).NotTo(HaveOccurred())
+
+			actualDigest, actualError = client.ResolveDigest(ctx, registryHost+"/rode/app:v1.2.3")
+		})
+
+		AfterEach(func() {
+			server.Close()
+		})
+
+		When("the registry allows anonymous access", func() {
+			It("should return the manifest digest", func() {
+				Expect(actualError).NotTo(HaveOccurred())
+				Expect(actualDigest).To(Equal(expectedDigest))
+			})
+
+			It("should accept OCI and Docker manifests", func() {
+				Expect(requests[0].Header.Get("Accept")).To(ContainSubstring("application/vnd.oci.image.index.v1+json"))
+				Expect(requests[0].Header.Get("Accept")).To(ContainSubstring("application/vnd.docker.distribution.manifest.v2+json"))
+			})
+		})
+
+		When("the registry requires a bearer token", func() {
+			var (
+				username string
+				password string
+			)
+
+			BeforeEach(func() {
+				username = fake.Username()
+				password = fake.Password(true, true, true, false, false, 16)
+				token := fake.LetterN(20)
+				handleManifest := manifestHandler("Bearer " + token)
+
+				handler = func(w http.ResponseWriter, r *http.Request) {
+					if r.URL.Path != "/token" {
+						handleManifest(w, r)
+						return
+					}
+
+					actualUsername, actualPassword, ok := r.BasicAuth()
+					if !ok || actualUsername != username || actualPassword != password || r.URL.Query().Get("service") != "fake-registry" {
+						w.WriteHeader(http.StatusForbidden)
+						return
+					}
+
+					Expect(json.NewEncoder(w).Encode(map[string]string{"token": token})).To(Succeed())
+				}
+			})
+
+			When("credentials are provided as inputs", func() {
+				BeforeEach(func() {
+					conf.Username = username
+					conf.Password = password
+				})
+
+				It("should exchange the credentials for a token", func() {
+					Expect(actualError).NotTo(HaveOccurred())
+					Expect(actualDigest).To(Equal(expectedDigest))
+					Expect(requests).To(HaveLen(3))
+				})
+			})
+
+			When("credentials are in the docker config", func() {
+				BeforeEach(func() {
+					auth := base64.StdEncoding.EncodeToString([]byte(username + ":" + password))
+					writeDockerConfig = func(host string) {
+						contents := fmt.Sprintf(`{"auths": {"https://%s": {"auth": %q}}}`, host, auth)
+						Expect(os.WriteFile(filepath.Join(conf.DockerConfig, "config.json"), []byte(contents), 0600)).To(Succeed())
+					}
+				})
+
+				It("should use the credentials for the registry", func() {
+					Expect(actualError).NotTo(HaveOccurred())
+					Expect(actualDigest).To(Equal(expectedDigest))
+				})
+			})
+
+			When("no credentials are available", func() {
+				It("should return an error", func() {
+					Expect(actualError).To(HaveOccurred())
+					Expect(actualError.Error()).To(ContainSubstring("unexpected status 403"))
+				})
+			})
+		})
+
+		When("the registry does not know the tag", func() {
+			BeforeEach(func() {
+				handler = func(w http.ResponseWriter, r *http.Request) {
+					w.WriteHeader(http.StatusNotFound)
+				}
+			})
+
+			It("should return an error", func() {
+				Expect(actualError).To(HaveOccurred())
+				Expect(actualError.Error()).To(ContainSubstring("unexpected status 404"))
+			})
+		})
+
+		When("the registry does not return a digest", func() {
+			BeforeEach(func() {
+				handler = func(w http.ResponseWriter, r *http.Request) {
+					w.WriteHeader(http.StatusOK)
+				}
+			})
+
+			It("should return an error", func() {
+				Expect(actualError).To(HaveOccurred())
+				Expect(actualError.Error()).To(ContainSubstring("did not return a sha256 digest"))
+			})
+		})
+	})
+
+	Describe("loadDockerConfig", func() {
+		It("should read credentials keyed by registry host", func() {
+			dir := tempDir()
+			auth := base64.StdEncoding.EncodeToString([]byte("user:pass"))
+			contents := fmt.Sprintf(`{"auths": {"https://index.docker.io/v1/": {"auth": %q}, "harbor.example.com": {"username": "robot", "password": "secret"}}}`, auth)
+			Expect(os.WriteFile(filepath.Join(dir, "config.json"), []byte(contents), 0600)).To(Succeed())
+
+			client, err := newRegistryClient(http.DefaultClient, &registryConfig{DockerConfig: dir})
+
+			Expect(err).NotTo(HaveOccurred())
+			Expect(client.credentials).To(Equal(map[string]registryCredential{
+				"docker.io":          {username: "user", password: "pass"},
+				"harbor.example.com": {username: "robot", password: "secret"},
+			}))
+		})
+
+		When("the directory isn't set", func() {
+			AfterEach(func() {
+				os.Unsetenv("DOCKER_CONFIG")
+			})
+
+			It("should use DOCKER_CONFIG", func() {
+				dir := tempDir()
+				Expect(os.WriteFile(filepath.Join(dir, "config.json"), []byte(`{"auths": {"harbor.example.com": {"username": "robot", "password": "secret"}}}`), 0600)).To(Succeed())
+				os.Setenv("DOCKER_CONFIG", dir)
+
+				client, err := newRegistryClient(http.DefaultClient, &registryConfig{})
+
+				Expect(err).NotTo(HaveOccurred())
+				Expect(client.credentials).To(HaveKeyWithValue("harbor.example.com", registryCredential{username: "robot", password: "secret"}))
+			})
+		})
+	})
+
+	Describe("parseAuthenticateChallenge", func() {
+		It("should parse the scheme and parameters", func() {
+			scheme, params := parseAuthenticateChallenge(`Bearer realm="https://auth.docker.io/token",service="registry.docker.io",scope="repository:library/alpine:pull,push"`)
+
+			Expect(scheme).To(Equal("Bearer"))
+			Expect(params).To(Equal(map[string]string{
+				"realm":   "https://auth.docker.io/token",
+				"service": "registry.docker.io",
+				"scope":   "repository:library/alpine:pull,push",
+			}))
+		})
+	})
+})
@@ -0,0 +1,253 @@
+// Copyright 2021 The Rode Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+const (
+	sbomFormatSPDX      = "SPDX"
+	sbomFormatCycloneDX = "CycloneDX"
+
+	cycloneDXNamespacePrefix = "http://cyclonedx.org/schema/bom/"
+)
+
+// sbomDocument summarizes a software bill of materials that was produced alongside the artifact
+type sbomDocument struct {
+	Path        string
+	Format      string
+	SpecVersion string
+	Digest      string
+	Components  int
+}
+
+type spdxJSONDocument struct {
+	SPDXVersion string            `json:"spdxVersion"`
+	SPDXID      string            `json:"SPDXID"`
+	Packages    []json.RawMessage `json:"packages"`
+}
+
+type cycloneDXJSONDocument struct {
+	BOMFormat   string                   `json:"bomFormat"`
+	SpecVersion string                   `json:"specVersion"`
+	Components  []cycloneDXJSONComponent `json:"components"`
+}
+
+type cycloneDXJSONComponent struct {
+	Components []cycloneDXJSONComponent `json:"components"`
+}
+
+type cycloneDXXMLDocument struct {
+	XMLName    xml.Name                `xml:"bom"`
+	Components []cycloneDXXMLComponent `xml:"components>component"`
+}
+
+type cycloneDXXMLComponent struct {
+	Components []cycloneDXXMLComponent `xml:"components>component"`
+}
+
+// findSboms expands the paths, which may be glob patterns, into the list of SBOM files
+func findSboms(paths []string) ([]string, error) {
+	var files []string
+	seen := map[string]bool{}
+	for _, pattern := range paths {
+		matches, err := filepath.Glob(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid SBOM path %q: %s", pattern, err)
+		}
+
+		if len(matches) == 0 {
+			return nil, fmt.Errorf("no SBOM found at %q", pattern)
+		}
+
+		sort.Strings(matches)
+		for _, match := range matches {
+			if seen[match] {
+				continue
+			}
+			seen[match] = true
+			files = append(files, match)
+		}
+	}
+
+	return files, nil
+}
+
+// parseSbom reads an SPDX (JSON or tag-value) or CycloneDX (JSON or XML) document and counts its components
+func parseSbom(path string) (*sbomDocument, error) {
+	contents, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read SBOM: %s", err)
+	}
+
+	document := &sbomDocument{
+		Path:   path,
+		Digest: sha256Digest(contents),
+	}
+
+	trimmed := bytes.TrimSpace(contents)
+	switch {
+	case bytes.HasPrefix(trimmed, []byte("{")):
+		err = parseJSONSbom(trimmed, document)
+	case bytes.HasPrefix(trimmed, []byte("<")):
+		err = parseCycloneDXXML(trimmed, document)
+	default:
+		err = parseSPDXTagValue(trimmed, document)
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("%s is not a valid SBOM: %s", path, err)
+	}
+
+	return document, nil
+}
+
+func parseJSONSbom(contents []byte, document *sbomDocument) error {
+	fields := map[string]json.RawMessage{}
+	if err := json.Unmarshal(contents, &fields); err != nil {
+		return err
+	}
+
+	if _, ok := fields["spdxVersion"]; ok {
+		spdx := &spdxJSONDocument{}
+		if err := json.Unmarshal(contents, spdx); err != nil {
+			return err
+		}
+
+		if !strings.HasPrefix(spdx.SPDXVersion, "SPDX-") {
+			return fmt.Errorf("unrecognized spdxVersion %q", spdx.SPDXVersion)
+		}
+
+		if spdx.SPDXID != "SPDXRef-DOCUMENT" {
+			return fmt.Errorf("SPDXID must be SPDXRef-DOCUMENT")
+		}
+
+		document.Format = sbomFormatSPDX
+		document.SpecVersion = strings.TrimPrefix(spdx.SPDXVersion, "SPDX-")
+		document.Components = len(spdx.Packages)
+
+		return nil
+	}
+
+	cycloneDX := &cycloneDXJSONDocument{}
+	if err := json.Unmarshal(contents, cycloneDX); err != nil {
+		return err
+	}
+
+	if cycloneDX.BOMFormat != sbomFormatCycloneDX {
+		return fmt.Errorf("document is neither SPDX nor CycloneDX")
+	}
+
+	if cycloneDX.SpecVersion == "" {
+		return fmt.Errorf("specVersion is required")
+	}
+
+	document.Format = sbomFormatCycloneDX
+	document.SpecVersion = cycloneDX.SpecVersion
+	document.Components = countCycloneDXJSONComponents(cycloneDX.Components)
+
+	return nil
+}
+
+func countCycloneDXJSONComponents(components []cycloneDXJSONComponent) int {
+	count := len(components)
+	for _, component := range components {
+		count += countCycloneDXJSONComponents(component.Components)
+	}
+
+	return count
+}
+
+func parseCycloneDXXML(contents []byte, document *sbomDocument) error {
+	cycloneDX := &cycloneDXXMLDocument{}
+	if err := xml.Unmarshal(contents, cycloneDX); err != nil {
+		return err
+	}
+
+	namespace := cycloneDX.XMLName.Space
+	if !strings.HasPrefix(namespace, cycloneDXNamespacePrefix) {
+		return fmt.Errorf("unrecognized XML namespace %q", namespace)
+	}
+
+	document.Format = sbomFormatCycloneDX
+	document.SpecVersion = strings.TrimPrefix(namespace, cycloneDXNamespacePrefix)
+	document.Components = countCycloneDXXMLComponents(cycloneDX.Components)
+
+	return nil
+}
+
+func countCycloneDXXMLComponents(components []cycloneDXXMLComponent) int {
+	count := len(components)
+	for _, component := range components {
+		count += countCycloneDXXMLComponents(component.Components)
+	}
+
+	return count
+}
+
+func parseSPDXTagValue(contents []byte, document *sbomDocument) error {
+	scanner := bufio.NewScanner(bytes.NewReader(contents))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		parts := strings.SplitN(line, ":", 2)
+		if len(parts) != 2 {
+			continue
+		}
+
+		switch strings.TrimSpace(parts[0]) {
+		case "SPDXVersion":
+			document.SpecVersion = strings.TrimPrefix(strings.TrimSpace(parts[1]), "SPDX-")
+		case "PackageName":
+			document.Components++
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+
+	if document.SpecVersion == "" {
+		return fmt.Errorf("document is neither SPDX nor CycloneDX")
+	}
+	document.Format = sbomFormatSPDX
+
+	return nil
+}
+
+// sbomSummary renders the SBOMs as a markdown table for the job summary
+func sbomSummary(documents []*sbomDocument) string {
+	var builder strings.Builder
+	builder.WriteString("### Software Bill of Materials\n\n")
+	builder.WriteString("| SBOM | Format | Components | Digest |\n")
+	builder.WriteString("|------|--------|------------|--------|\n")
+	for _, document := range documents {
+		builder.WriteString(fmt.Sprintf("| `%s` | %s %s | %d | `sha256:%s` |\n", document.Path, document.Format, document.SpecVersion, document.Components, document.Digest))
+	}
+
+	return builder.String()
+}
@@ -0,0 +1,127 @@
+// Copyright 2021 The Rode Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+)
+
+const (
+	spdxJSONSbom = `{
+  "spdxVersion": "SPDX-2.2",
+  "SPDXID": "SPDXRef-DOCUMENT",
+  "name": "demo-app",
+  "packages": [
+    {"SPDXID": "SPDXRef-Package-alpine", "name": "alpine-baselayout"},
+    {"SPDXID": "SPDXRef-Package-musl", "name": "musl"}
+  ]
+}`
+	spdxTagValueSbom = `SPDXVersion: SPDX-2.2
+DataLicense: CC0-1.0
+SPDXID: SPDXRef-DOCUMENT
+# packages
+PackageName: alpine-baselayout
+SPDXID: SPDXRef-Package-alpine
+PackageName: musl
+SPDXID: SPDXRef-Package-musl
+PackageName: zlib
+`
+	cycloneDXJSONSbom = `{
+  "bomFormat": "CycloneDX",
+  "specVersion": "1.3",
+  "components": [
+    {"type": "library", "name": "express", "components": [{"type": "library", "name": "body-parser"}]},
+    {"type": "library", "name": "lodash"}
+  ]
+}`
+	cycloneDXXMLSbom = `<?xml version="1.0" encoding="UTF-8"?>
+<bom xmlns="http://cyclonedx.org/schema/bom/1.3" version="1">
+  <components>
+    <component type="library"><name>express</name></component>
+  </components>
+</bom>`
+)
+
+func writeSbom(dir, name, contents string) string {
+	path := filepath.Join(dir, name)
+	Expect(os.WriteFile(path, []byte(contents), 0644)).To(Succeed())
+
+	return path
+}
+
+var _ = Describe("sbom", func() {
+	DescribeTable("parseSbom",
+		func(contents, expectedFormat, expectedVersion string, expectedComponents int) {
+			path := writeSbom(tempDir(), "sbom", contents)
+
+			document, err := parseSbom(path)
+
+			Expect(err).NotTo(HaveOccurred())
+			Expect(document.Path).To(Equal(path))
+			Expect(document.Format).To(Equal(expectedFormat))
+			Expect(document.SpecVersion).To(Equal(expectedVersion))
+			Expect(document.Components).To(Equal(expectedComponents))
+			Expect(document.Digest).To(Equal(sha256Digest([]byte(contents))))
+		},
+		Entry("SPDX JSON", spdxJSONSbom, "SPDX", "2.2", 2),
+		Entry("SPDX tag-value", spdxTagValueSbom, "SPDX", "2.2", 3),
+		Entry("CycloneDX JSON with nested components", cycloneDXJSONSbom, "CycloneDX", "1.3", 3),
+		Entry("CycloneDX XML", cycloneDXXMLSbom, "CycloneDX", "1.3", 1),
+		Entry("empty CycloneDX JSON", `{"bomFormat": "CycloneDX", "specVersion": "1.4"}`, "CycloneDX", "1.4", 0),
+	)
+
+	DescribeTable("invalid SBOMs",
+		func(contents string) {
+			_, err := parseSbom(writeSbom(tempDir(), "sbom", contents))
+
+			Expect(err).To(MatchError(ContainSubstring("is not a valid SBOM")))
+		},
+		Entry("other JSON", `{"foo": "bar"}`),
+		Entry("malformed JSON", `{"spdxVersion": `),
+		Entry("SPDX without document id", `{"spdxVersion": "SPDX-2.2"}`),
+		Entry("CycloneDX without spec version", `{"bomFormat": "CycloneDX"}`),
+		Entry("other XML", `<project xmlns="http://maven.apache.org/POM/4.0.0"></project>`),
+		Entry("plain text", "hello world"),
+	)
+
+	Describe("findSboms", func() {
+		var dir string
+
+		BeforeEach(func() {
+			dir = tempDir()
+			writeSbom(dir, "b.spdx.json", spdxJSONSbom)
+			writeSbom(dir, "a.spdx.json", spdxJSONSbom)
+			writeSbom(dir, "c.cdx.json", cycloneDXJSONSbom)
+		})
+
+		It("should expand glob patterns without duplicates", func() {
+			paths, err := findSboms([]string{filepath.Join(dir, "*.spdx.json"), filepath.Join(dir, "a.spdx.json")})
+
+			Expect(err).NotTo(HaveOccurred())
+			Expect(paths).To(Equal([]string{filepath.Join(dir, "a.spdx.json"), filepath.Join(dir, "b.spdx.json")}))
+		})
+
+		It("should return an error when nothing matches", func() {
+			_, err := findSboms([]string{filepath.Join(dir, "*.xml")})
+
+			Expect(err).To(MatchError(ContainSubstring("no SBOM found")))
+		})
+	})
+})
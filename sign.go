@@ -0,0 +1,208 @@
+// Copyright 2021 The Rode Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/youmark/pkcs8"
+)
+
+const (
+	inTotoPayloadType       = "application/vnd.in-toto+json"
+	buildRequestPayloadType = "application/vnd.rode.build-request+json"
+)
+
+// dsseEnvelope is a signed payload as described by https://github.com/secure-systems-lab/dsse
+type dsseEnvelope struct {
+	PayloadType string          `json:"payloadType"`
+	Payload     string          `json:"payload"`
+	Signatures  []dsseSignature `json:"signatures"`
+}
+
+type dsseSignature struct {
+	KeyId string `json:"keyid"`
+	Sig   string `json:"sig"`
+}
+
+// loadSigningKey parses an ECDSA or ed25519 private key. Encrypted keys must be PKCS#8 (ENCRYPTED PRIVATE KEY), as produced
+// by `openssl pkcs8 -topk8 -v2 aes-256-cbc`. The legacy PEM encryption (Proc-Type: 4,ENCRYPTED) is rejected, because it derives
+// the key from a single MD5 of the passphrase.
+func loadSigningKey(pemBytes []byte, passphrase string) (crypto.Signer, error) {
+	block, _ := pem.Decode(pemBytes)
+	if block == nil {
+		return nil, errors.New("signing key is not PEM encoded")
+	}
+
+	if strings.Contains(block.Headers["Proc-Type"], "ENCRYPTED") {
+		return nil, errors.New("signing key uses the insecure legacy PEM encryption, convert it with `openssl pkcs8 -topk8 -v2 aes-256-cbc`")
+	}
+
+	var key interface{}
+	var err error
+	switch block.Type {
+	case "EC PRIVATE KEY":
+		key, err = x509.ParseECPrivateKey(block.Bytes)
+	case "PRIVATE KEY":
+		key, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	case "ENCRYPTED PRIVATE KEY":
+		if passphrase == "" {
+			return nil, errors.New("signing key is encrypted, but no passphrase was provided")
+		}
+
+		key, err = pkcs8.ParsePKCS8PrivateKey(block.Bytes, []byte(passphrase))
+		if err != nil {
+			return nil, fmt.Errorf("unable to decrypt signing key: %s", err)
+		}
+	default:
+		return nil, fmt.Errorf("unsupported signing key type %q", block.Type)
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse signing key: %s", err)
+	}
+
+	switch k := key.(type) {
+	case *ecdsa.PrivateKey:
+		return k, nil
+	case ed25519.PrivateKey:
+		return k, nil
+	}
+
+	return nil, fmt.Errorf("unsupported signing key algorithm %T, expected ECDSA or ed25519", key)
+}
+
+// loadVerificationKey parses a PEM encoded public key or certificate
+func loadVerificationKey(pemBytes []byte) (crypto.PublicKey, error) {
+	block, _ := pem.Decode(pemBytes)
+	if block == nil {
+		return nil, errors.New("verification key is not PEM encoded")
+	}
+
+	switch block.Type {
+	case "PUBLIC KEY":
+		return x509.ParsePKIXPublicKey(block.Bytes)
+	case "CERTIFICATE":
+		certificate, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+
+		return certificate.PublicKey, nil
+	}
+
+	return nil, fmt.Errorf("unsupported verification key type %q", block.Type)
+}
+
+// keyFingerprint is the sha256 digest of the DER encoded public key, and is used as the DSSE key id
+func keyFingerprint(publicKey crypto.PublicKey) (string, error) {
+	der, err := x509.MarshalPKIXPublicKey(publicKey)
+	if err != nil {
+		return "", err
+	}
+
+	sum := sha256.Sum256(der)
+
+	return "SHA256:" + hex.EncodeToString(sum[:]), nil
+}
+
+// preAuthenticationEncoding is the DSSE v1 PAE, which is what's actually signed
+func preAuthenticationEncoding(payloadType string, payload []byte) []byte {
+	return []byte(fmt.Sprintf("DSSEv1 %d %s %d %s", len(payloadType), payloadType, len(payload), payload))
+}
+
+func signEnvelope(signer crypto.Signer, payloadType string, payload []byte) (*dsseEnvelope, error) {
+	keyId, err := keyFingerprint(signer.Public())
+	if err != nil {
+		return nil, err
+	}
+
+	message := preAuthenticationEncoding(payloadType, payload)
+	var signature []byte
+	switch signer.(type) {
+	case ed25519.PrivateKey:
+		signature, err = signer.Sign(rand.Reader, message, crypto.Hash(0))
+	default:
+		digest := sha256.Sum256(message)
+		signature, err = signer.Sign(rand.Reader, digest[:], crypto.SHA256)
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("error signing payload: %s", err)
+	}
+
+	return &dsseEnvelope{
+		PayloadType: payloadType,
+		Payload:     base64.StdEncoding.EncodeToString(payload),
+		Signatures: []dsseSignature{
+			{
+				KeyId: keyId,
+				Sig:   base64.StdEncoding.EncodeToString(signature),
+			},
+		},
+	}, nil
+}
+
+// verifyEnvelope checks that at least one of the envelope signatures was made by the key, and returns the decoded payload
+func verifyEnvelope(envelope *dsseEnvelope, publicKey crypto.PublicKey) ([]byte, error) {
+	payload, err := base64.StdEncoding.DecodeString(envelope.Payload)
+	if err != nil {
+		return nil, fmt.Errorf("unable to decode payload: %s", err)
+	}
+
+	keyId, err := keyFingerprint(publicKey)
+	if err != nil {
+		return nil, err
+	}
+
+	message := preAuthenticationEncoding(envelope.PayloadType, payload)
+	digest := sha256.Sum256(message)
+	for _, s := range envelope.Signatures {
+		if s.KeyId != "" && s.KeyId != keyId {
+			continue
+		}
+
+		signature, err := base64.StdEncoding.DecodeString(s.Sig)
+		if err != nil {
+			continue
+		}
+
+		switch k := publicKey.(type) {
+		case *ecdsa.PublicKey:
+			if ecdsa.VerifyASN1(k, digest[:], signature) {
+				return payload, nil
+			}
+		case ed25519.PublicKey:
+			if ed25519.Verify(k, message, signature) {
+				return payload, nil
+			}
+		default:
+			return nil, fmt.Errorf("unsupported verification key algorithm %T", publicKey)
+		}
+	}
+
+	return nil, fmt.Errorf("no valid signature found for key %s", keyId)
+}
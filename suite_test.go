@@ -15,6 +15,8 @@
 package main
 
 import (
+	"os"
+
 	"github.com/brianvoe/gofakeit/v6"
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
@@ -30,3 +32,21 @@ func TestAction(t *testing.T) {
 	RegisterFailHandler(Fail)
 	RunSpecs(t, "Action Suite")
 }
+
+var tempDirs []string
+
+var _ = AfterEach(func() {
+	for _, dir := range tempDirs {
+		Expect(os.RemoveAll(dir)).To(Succeed())
+	}
+	tempDirs = nil
+})
+
+// tempDir creates a directory that is removed once the current spec finishes
+func tempDir() string {
+	dir, err := os.MkdirTemp("", "create-build-occurrence-action")
+	Expect(err).NotTo(HaveOccurred())
+	tempDirs = append(tempDirs, dir)
+
+	return dir
+}
@@ -0,0 +1,77 @@
+// Copyright 2021 The Rode Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// withTimeout is context.WithTimeout, except that a timeout of zero means there's no deadline
+func withTimeout(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+
+	return context.WithTimeout(ctx, timeout)
+}
+
+// newHTTPClient is used for the CI system's API and the image registry, so that each request is limited by the RPC timeout
+func newHTTPClient(c *timeoutConfig) *http.Client {
+	return &http.Client{Timeout: c.Rpc}
+}
+
+// describeTimeout explains whether the action was cancelled or which of the timeouts cut the request short, so that it's clear
+// which one to raise. ctx is the context of the whole action, err is the error from a request.
+func describeTimeout(ctx context.Context, err error, c *timeoutConfig) error {
+	if errors.Is(ctx.Err(), context.Canceled) {
+		return fmt.Errorf("the action was cancelled: %s", err)
+	}
+
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return fmt.Errorf("the action didn't finish within %s, set TIMEOUT_TOTAL to allow longer: %s", c.Total, err)
+	}
+
+	if errors.Is(err, context.DeadlineExceeded) || status.Code(err) == codes.DeadlineExceeded {
+		return fmt.Errorf("no response within %s, set TIMEOUT_RPC to wait longer: %s", c.Rpc, err)
+	}
+
+	return err
+}
+
+func validateTimeouts(c *timeoutConfig) configErrors {
+	var problems configErrors
+	timeouts := []struct {
+		name  string
+		value time.Duration
+	}{{"TIMEOUT_DIAL", c.Dial}, {"TIMEOUT_RPC", c.Rpc}, {"TIMEOUT_TOTAL", c.Total}}
+	for _, timeout := range timeouts {
+		if timeout.value < 0 {
+			problems = append(problems, fmt.Sprintf("%s: must not be negative, use 0 for no timeout", timeout.name))
+		}
+	}
+
+	if c.Total > 0 && c.Rpc > c.Total {
+		problems = append(problems, fmt.Sprintf("TIMEOUT_RPC: %s is longer than TIMEOUT_TOTAL of %s", c.Rpc, c.Total))
+	}
+
+	return problems
+}
@@ -0,0 +1,112 @@
+// Copyright 2021 The Rode Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"net"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("timeouts", func() {
+	var timeouts *timeoutConfig
+
+	BeforeEach(func() {
+		timeouts = &timeoutConfig{Dial: 50 * time.Millisecond, Rpc: 30 * time.Second, Total: 5 * time.Minute}
+	})
+
+	Describe("withTimeout", func() {
+		It("should not set a deadline for a timeout of zero", func() {
+			ctx, cancel := withTimeout(context.Background(), 0)
+			defer cancel()
+
+			_, ok := ctx.Deadline()
+			Expect(ok).To(BeFalse())
+		})
+
+		It("should set a deadline", func() {
+			ctx, cancel := withTimeout(context.Background(), time.Minute)
+			defer cancel()
+
+			deadline, ok := ctx.Deadline()
+			Expect(ok).To(BeTrue())
+			Expect(deadline).To(BeTemporally("~", time.Now().Add(time.Minute), time.Second))
+		})
+	})
+
+	Describe("describeTimeout", func() {
+		It("should leave other errors alone", func() {
+			err := errors.New("connection reset")
+
+			Expect(describeTimeout(context.Background(), err, timeouts)).To(Equal(err))
+		})
+	})
+
+	Describe("validateTimeouts", func() {
+		It("should accept the defaults", func() {
+			Expect(validateTimeouts(timeouts)).To(BeEmpty())
+		})
+
+		It("should report negative timeouts, and a request timeout longer than the total", func() {
+			timeouts.Dial = -time.Second
+			timeouts.Total = time.Second
+
+			Expect(validateTimeouts(timeouts)).To(Equal(configErrors{
+				"TIMEOUT_DIAL: must not be negative, use 0 for no timeout",
+				"TIMEOUT_RPC: 30s is longer than TIMEOUT_TOTAL of 1s",
+			}))
+		})
+	})
+
+	Describe("newBuildCollectorClient", func() {
+		var listener net.Listener
+
+		BeforeEach(func() {
+			var err error
+			listener, err = net.Listen("tcp", "127.0.0.1:0")
+			Expect(err).NotTo(HaveOccurred())
+
+			// accept connections but never respond, like a build collector that's hung
+			go func() {
+				for {
+					conn, err := listener.Accept()
+					if err != nil {
+						return
+					}
+					defer conn.Close()
+				}
+			}()
+		})
+
+		AfterEach(func() {
+			listener.Close()
+		})
+
+		It("should give up after the dial timeout", func() {
+			conf := &config{
+				BuildCollector: &buildCollectorConfig{Host: listener.Addr().String(), Insecure: true},
+				Timeout:        timeouts,
+			}
+
+			_, _, err := newBuildCollectorClient(context.Background(), conf, defaultCollectorTarget(conf))
+
+			Expect(err).To(MatchError("no connection within 50ms, set TIMEOUT_DIAL to wait longer"))
+		})
+	})
+})
@@ -0,0 +1,181 @@
+// Copyright 2021 The Rode Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.4.0"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	serviceName = "create-build-occurrence-action"
+	tracerName  = "github.com/rode/create-build-occurrence-action"
+)
+
+// tracer uses the global tracer provider, which does nothing unless setupTracing has been called with an endpoint
+func tracer() trace.Tracer {
+	return otel.Tracer(tracerName)
+}
+
+// setupTracing exports spans to the configured OTLP/HTTP endpoint. The returned function sends any spans that are still buffered,
+// it's called before the action exits.
+func setupTracing(ctx context.Context, c *config) (func(), error) {
+	if c.Tracing.Endpoint == "" {
+		return func() {}, nil
+	}
+
+	// tracing starts before the rest of the config is validated, so that validation is part of the trace
+	if problems := validateUrl("TRACING_ENDPOINT", c.Tracing.Endpoint); len(problems) > 0 {
+		return nil, problems
+	}
+
+	endpoint, _ := url.Parse(c.Tracing.Endpoint)
+
+	options := []otlptracehttp.Option{otlptracehttp.WithEndpoint(endpoint.Host)}
+	if endpoint.Scheme == "http" {
+		options = append(options, otlptracehttp.WithInsecure())
+	}
+
+	if path := strings.TrimSuffix(endpoint.Path, "/"); path != "" {
+		options = append(options, otlptracehttp.WithURLPath(path))
+	}
+
+	if c.Timeout.Rpc > 0 {
+		options = append(options, otlptracehttp.WithTimeout(c.Timeout.Rpc))
+	}
+
+	exporter, err := otlptracehttp.New(ctx, options...)
+	if err != nil {
+		return nil, fmt.Errorf("unable to create trace exporter: %s", err)
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(resource.NewWithAttributes(
+			semconv.SchemaURL,
+			semconv.ServiceNameKey.String(serviceName),
+			semconv.ServiceVersionKey.String(version),
+		)),
+	)
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+
+	return func() {
+		// the action's context may already be cancelled, the spans are still worth sending
+		ctx, cancel := withTimeout(context.Background(), c.Timeout.Rpc)
+		defer cancel()
+
+		if err := provider.Shutdown(ctx); err != nil {
+			fmt.Fprintf(os.Stderr, "unable to send traces: %s\n", err)
+		}
+	}, nil
+}
+
+// traceCommand starts the span for a command once its config has been loaded, backdated to when the command started so that
+// loading the config is included. The returned function ends the span and flushes the traces.
+func traceCommand(ctx context.Context, c *config, name string, started time.Time) (context.Context, func(error), error) {
+	loaded := time.Now()
+	shutdown, err := setupTracing(ctx, c)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	ctx, span := tracer().Start(ctx, name, trace.WithTimestamp(started))
+	_, load := tracer().Start(ctx, "load config", trace.WithTimestamp(started))
+	load.End(trace.WithTimestamp(loaded))
+
+	return ctx, func(err error) {
+		endSpan(span, err)
+		shutdown()
+	}, nil
+}
+
+// endSpan marks the span as failed when there's an error
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+
+	span.End()
+}
+
+// tracingInterceptor records a span for each call to the build collector, and passes the trace context in the call's metadata so
+// that the build collector's spans join the same trace
+func tracingInterceptor(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) (err error) {
+	name := strings.TrimPrefix(method, "/")
+	attributes := []attribute.KeyValue{semconv.RPCSystemKey.String("grpc"), semconv.NetPeerNameKey.String(cc.Target())}
+	if i := strings.LastIndex(name, "/"); i != -1 {
+		attributes = append(attributes, semconv.RPCServiceKey.String(name[:i]), semconv.RPCMethodKey.String(name[i+1:]))
+	}
+
+	ctx, span := tracer().Start(ctx, name, trace.WithSpanKind(trace.SpanKindClient), trace.WithAttributes(attributes...))
+	defer func() {
+		span.SetAttributes(semconv.RPCGRPCStatusCodeKey.Int64(int64(status.Code(err))))
+		endSpan(span, err)
+	}()
+
+	md, ok := metadata.FromOutgoingContext(ctx)
+	if ok {
+		md = md.Copy()
+	} else {
+		md = metadata.MD{}
+	}
+	otel.GetTextMapPropagator().Inject(ctx, metadataCarrier(md))
+
+	return invoker(metadata.NewOutgoingContext(ctx, md), method, req, reply, cc, opts...)
+}
+
+// metadataCarrier lets the propagator write the trace context to gRPC metadata
+type metadataCarrier metadata.MD
+
+func (m metadataCarrier) Get(key string) string {
+	values := metadata.MD(m).Get(key)
+	if len(values) == 0 {
+		return ""
+	}
+
+	return values[0]
+}
+
+func (m metadataCarrier) Set(key, value string) {
+	metadata.MD(m).Set(key, value)
+}
+
+func (m metadataCarrier) Keys() []string {
+	var keys []string
+	for key := range m {
+		keys = append(keys, key)
+	}
+
+	return keys
+}
@@ -0,0 +1,219 @@
+// Copyright 2021 The Rode Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	"github.com/google/go-github/v35/github"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	collector "github.com/rode/collector-build/proto/v1alpha1"
+	"github.com/rode/create-build-occurrence-action/mocks"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+// metadataCollector keeps the metadata of the last request it received
+type metadataCollector struct {
+	collector.UnimplementedBuildCollectorServer
+	md metadata.MD
+}
+
+func (m *metadataCollector) CreateBuild(ctx context.Context, _ *collector.CreateBuildRequest) (*collector.CreateBuildResponse, error) {
+	m.md, _ = metadata.FromIncomingContext(ctx)
+
+	return &collector.CreateBuildResponse{BuildOccurrenceId: "123"}, nil
+}
+
+func findSpan(spans tracetest.SpanStubs, name string) tracetest.SpanStub {
+	for _, span := range spans {
+		if span.Name == name {
+			return span
+		}
+	}
+	Fail("no span named " + name)
+
+	return tracetest.SpanStub{}
+}
+
+var _ = Describe("tracing", func() {
+	var (
+		ctx      context.Context
+		exporter *tracetest.InMemoryExporter
+	)
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		exporter = tracetest.NewInMemoryExporter()
+		otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter)))
+		otel.SetTextMapPropagator(propagation.TraceContext{})
+	})
+
+	AfterEach(func() {
+		otel.SetTracerProvider(trace.NewNoopTracerProvider())
+		otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator())
+	})
+
+	Describe("calls to the build collector", func() {
+		var (
+			server   *grpc.Server
+			received *metadataCollector
+			conf     *config
+		)
+
+		BeforeEach(func() {
+			listener, err := net.Listen("tcp", "127.0.0.1:0")
+			Expect(err).NotTo(HaveOccurred())
+
+			received = &metadataCollector{}
+			server = grpc.NewServer()
+			collector.RegisterBuildCollectorServer(server, received)
+			go server.Serve(listener)
+
+			conf = &config{
+				BuildCollector: &buildCollectorConfig{Host: listener.Addr().String(), Insecure: true},
+				Timeout:        &timeoutConfig{Dial: time.Second},
+			}
+		})
+
+		AfterEach(func() {
+			server.Stop()
+		})
+
+		It("should trace the dial and the request, and pass the trace context to the build collector", func() {
+			ctx, span := tracer().Start(ctx, "run")
+			conn, client, err := newBuildCollectorClient(ctx, conf, defaultCollectorTarget(conf))
+			Expect(err).NotTo(HaveOccurred())
+			defer conn.Close()
+
+			_, err = client.CreateBuild(ctx, &collector.CreateBuildRequest{})
+			Expect(err).NotTo(HaveOccurred())
+			span.End()
+
+			spans := exporter.GetSpans()
+			Expect(spans).To(HaveLen(3))
+			dial := findSpan(spans, "dial build collector")
+			Expect(dial.Parent.SpanID()).To(Equal(span.SpanContext().SpanID()))
+
+			request := findSpan(spans, "build_collector.v1alpha1.BuildCollector/CreateBuild")
+			Expect(request.SpanKind).To(Equal(trace.SpanKindClient))
+			Expect(request.Parent.SpanID()).To(Equal(span.SpanContext().SpanID()))
+			Expect(request.Attributes).To(ContainElements(
+				attribute.String("rpc.system", "grpc"),
+				attribute.String("rpc.service", "build_collector.v1alpha1.BuildCollector"),
+				attribute.String("rpc.method", "CreateBuild"),
+				attribute.Int64("rpc.grpc.status_code", 0),
+			))
+
+			traceparent := received.md.Get("traceparent")
+			Expect(traceparent).To(HaveLen(1))
+			Expect(traceparent[0]).To(Equal("00-" + request.SpanContext.TraceID().String() + "-" + request.SpanContext.SpanID().String() + "-01"))
+		})
+
+		It("should mark a failed dial", func() {
+			server.Stop()
+
+			_, _, err := newBuildCollectorClient(ctx, conf, defaultCollectorTarget(conf))
+			Expect(err).To(HaveOccurred())
+
+			dial := findSpan(exporter.GetSpans(), "dial build collector")
+			Expect(dial.Status.Code).To(Equal(codes.Error))
+		})
+	})
+
+	Describe("traceCommand", func() {
+		It("should include loading the config in the command's span", func() {
+			started := time.Now().Add(-time.Second)
+
+			_, finish, err := traceCommand(ctx, &config{Tracing: &tracingConfig{}}, "run", started)
+			Expect(err).NotTo(HaveOccurred())
+			finish(errors.New("error creating build occurrence"))
+
+			spans := exporter.GetSpans()
+			Expect(spans).To(HaveLen(2))
+			load := findSpan(spans, "load config")
+			run := findSpan(spans, "run")
+			Expect(run.StartTime).To(Equal(started))
+			Expect(load.StartTime).To(Equal(started))
+			Expect(load.Parent.SpanID()).To(Equal(run.SpanContext.SpanID()))
+			Expect(run.Status.Code).To(Equal(codes.Error))
+			Expect(run.Status.Description).To(Equal("error creating build occurrence"))
+		})
+	})
+
+	Describe("the GitHub job lookup", func() {
+		It("should record the run and job", func() {
+			actions := &mocks.FakeActionsService{}
+			actions.ListWorkflowJobsReturns(&github.Jobs{Jobs: []*github.WorkflowJob{{Name: github.String("build")}}}, nil, nil)
+			provider := &githubProvider{actions: actions, config: &githubConfig{RepoSlug: "rode/demo-app", RunId: 42, JobId: "build"}}
+
+			job, _, err := provider.currentJob(ctx, "rode", "demo-app")
+
+			Expect(err).NotTo(HaveOccurred())
+			Expect(job.GetName()).To(Equal("build"))
+			lookup := findSpan(exporter.GetSpans(), "look up GitHub job")
+			Expect(lookup.Attributes).To(ContainElements(
+				attribute.String("github.repository", "rode/demo-app"),
+				attribute.Int64("github.run_id", 42),
+				attribute.String("github.job", "build"),
+				attribute.Int("github.jobs", 1),
+			))
+		})
+	})
+
+	Describe("setupTracing", func() {
+		It("should do nothing without an endpoint", func() {
+			shutdown, err := setupTracing(ctx, &config{Tracing: &tracingConfig{}})
+
+			Expect(err).NotTo(HaveOccurred())
+			shutdown()
+		})
+
+		It("should send spans to the endpoint", func() {
+			paths := make(chan string, 1)
+			receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				paths <- r.URL.Path
+			}))
+			defer receiver.Close()
+
+			shutdown, err := setupTracing(ctx, &config{Tracing: &tracingConfig{Endpoint: receiver.URL}, Timeout: &timeoutConfig{Rpc: time.Second}})
+			Expect(err).NotTo(HaveOccurred())
+			_, span := tracer().Start(ctx, "run")
+			span.End()
+			shutdown()
+
+			Eventually(paths).Should(Receive(Equal("/v1/traces")))
+		})
+
+		It("should reject an endpoint that isn't a url", func() {
+			_, err := setupTracing(ctx, &config{Tracing: &tracingConfig{Endpoint: "otel-collector:4318"}})
+
+			Expect(err).To(MatchError(ContainSubstring("TRACING_ENDPOINT")))
+		})
+	})
+})
@@ -0,0 +1,167 @@
+// Copyright 2021 The Rode Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// configErrors lists every problem found with the configuration, so that they can all be fixed at once
+type configErrors []string
+
+func (e configErrors) Error() string {
+	return fmt.Sprintf("invalid configuration:\n  %s", strings.Join(e, "\n  "))
+}
+
+// validator is implemented by CI providers that can check their own configuration
+type validator interface {
+	validate() configErrors
+}
+
+// validateConfig checks the config and the provider before any network calls are made. The provider is nil when no
+// build is being created, in which case the artifact is only checked if checkArtifact is set.
+func validateConfig(c *config, provider ciProvider, checkArtifact bool) error {
+	var problems configErrors
+
+	problems = append(problems, validateCollectors(c)...)
+	problems = append(problems, validatePolicy(c)...)
+
+	if c.ArtifactNamesDelimiter == "" {
+		problems = append(problems, "ARTIFACT_NAMES_DELIMITER: must not be empty")
+	}
+
+	if c.Provenance.Version != slsaProvenanceV02 && c.Provenance.Version != slsaProvenanceV1 {
+		problems = append(problems, fmt.Sprintf("PROVENANCE_VERSION: %q is not supported, expected %s or %s", c.Provenance.Version, slsaProvenanceV02, slsaProvenanceV1))
+	}
+
+	if c.Provenance.Attach && c.Provenance.Path == "" {
+		problems = append(problems, "PROVENANCE_ATTACH: requires PROVENANCE_PATH to be set")
+	}
+
+	if c.Signing.KeyPassphrase != "" && c.Signing.Key == "" {
+		problems = append(problems, "SIGNING_KEY_PASSPHRASE: requires SIGNING_KEY to be set")
+	}
+
+	problems = append(problems, validateTime("BUILD_START", c.BuildStart)...)
+	problems = append(problems, validateTime("BUILD_END", c.BuildEnd)...)
+	if _, ok := provider.(*githubProvider); provider != nil && !ok {
+		if c.BuildSteps != "" {
+			problems = append(problems, "BUILD_STEPS: job steps are only available in GitHub Actions, use BUILD_START and BUILD_END instead")
+		}
+
+		if c.Scope == scopeWorkflow || c.WorkflowJobs != "" {
+			problems = append(problems, "SCOPE: the workflow scope is only available in GitHub Actions")
+		}
+
+		if c.RequireSignedCommit {
+			problems = append(problems, "REQUIRE_SIGNED_COMMIT: commit signatures are only verified in GitHub Actions")
+		}
+
+		if c.CommitSource == commitSourceHead {
+			problems = append(problems, "COMMIT_SOURCE: pull request commits are only read in GitHub Actions")
+		}
+	}
+
+	problems = append(problems, validateCreator(c, provider)...)
+	problems = append(problems, validateTimeouts(c.Timeout)...)
+	problems = append(problems, validateUrl("METRICS_PUSHGATEWAY_URL", c.Metrics.PushgatewayUrl)...)
+	problems = append(problems, validateUrl("TRACING_ENDPOINT", c.Tracing.Endpoint)...)
+
+	if checkArtifact || provider != nil {
+		if _, err := buildArtifact(c); err != nil {
+			problems = append(problems, fmt.Sprintf("ARTIFACT_ID: %s", err))
+		}
+	}
+
+	if v, ok := provider.(validator); ok {
+		problems = append(problems, v.validate()...)
+	}
+
+	if len(problems) > 0 {
+		return problems
+	}
+
+	return nil
+}
+
+// validateHostPort checks for an address that can be dialed, e.g. rode-collector-build:443
+func validateHostPort(name, value string) configErrors {
+	if strings.Contains(value, "://") {
+		return configErrors{fmt.Sprintf("%s: %q must be a host and port without a scheme, e.g. rode-collector-build.example.com:443", name, value)}
+	}
+
+	host, port, err := net.SplitHostPort(value)
+	if err != nil {
+		return configErrors{fmt.Sprintf("%s: %q must be in the form host:port", name, value)}
+	}
+
+	var problems configErrors
+	if host == "" {
+		problems = append(problems, fmt.Sprintf("%s: %q is missing a host", name, value))
+	}
+
+	if n, err := strconv.Atoi(port); err != nil || n < 1 || n > 65535 {
+		problems = append(problems, fmt.Sprintf("%s: %q has an invalid port, expected a number between 1 and 65535", name, value))
+	}
+
+	return problems
+}
+
+// validateUrl checks for an absolute http(s) url. Empty values are allowed, required values are checked by envconfig
+func validateUrl(name, value string) configErrors {
+	if value == "" {
+		return nil
+	}
+
+	u, err := url.Parse(value)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return configErrors{fmt.Sprintf("%s: %q must be an absolute http or https url", name, value)}
+	}
+
+	return nil
+}
+
+// validateTime checks for an RFC 3339 timestamp, e.g. 2021-06-01T12:30:00Z. Empty values are allowed
+func validateTime(name, value string) configErrors {
+	if value == "" {
+		return nil
+	}
+
+	if _, err := time.Parse(time.RFC3339, value); err != nil {
+		return configErrors{fmt.Sprintf("%s: %q must be in RFC 3339 format, e.g. 2021-06-01T12:30:00Z", name, value)}
+	}
+
+	return nil
+}
+
+func isLoopback(hostPort string) bool {
+	host, _, err := net.SplitHostPort(hostPort)
+	if err != nil {
+		return false
+	}
+
+	if host == "localhost" {
+		return true
+	}
+
+	ip := net.ParseIP(host)
+
+	return ip != nil && ip.IsLoopback()
+}
@@ -0,0 +1,160 @@
+// Copyright 2021 The Rode Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("validateConfig", func() {
+	var (
+		conf     *config
+		provider ciProvider
+	)
+
+	BeforeEach(func() {
+		conf = &config{
+			ArtifactId:             "harbor.example.com/rode/app@sha256:123",
+			ArtifactNamesDelimiter: "\n",
+			BuildCollector: &buildCollectorConfig{
+				Host: "rode-collector-build.example.com:443",
+			},
+			Metrics:    &metricsConfig{},
+			Policy:     &policyConfig{},
+			Provenance: &provenanceConfig{Version: slsaProvenanceV02},
+			Registry:   &registryConfig{},
+			Signing:    &signingConfig{},
+			Timeout:    &timeoutConfig{Dial: 5 * time.Second, Rpc: 30 * time.Second, Total: 5 * time.Minute},
+			Tracing:    &tracingConfig{},
+		}
+		provider = &githubProvider{
+			config: &githubConfig{
+				RepoSlug:  "rode/demo-app",
+				ServerUrl: "https://github.com",
+			},
+			runner: &runnerConfig{},
+		}
+	})
+
+	It("should accept a valid config", func() {
+		Expect(validateConfig(conf, provider, true)).To(Succeed())
+	})
+
+	DescribeTable("problems",
+		func(update func(), expected string) {
+			update()
+
+			err := validateConfig(conf, provider, true)
+
+			Expect(err).To(BeAssignableToTypeOf(configErrors{}))
+			Expect(err.(configErrors)).To(ConsistOf(expected))
+		},
+		Entry("host with a scheme", func() { conf.BuildCollector.Host = "https://rode:443" }, `BUILD_COLLECTOR_HOST: "https://rode:443" must be a host and port without a scheme, e.g. rode-collector-build.example.com:443`),
+		Entry("host without a port", func() { conf.BuildCollector.Host = "rode" }, `BUILD_COLLECTOR_HOST: "rode" must be in the form host:port`),
+		Entry("invalid port", func() { conf.BuildCollector.Host = "rode:http" }, `BUILD_COLLECTOR_HOST: "rode:http" has an invalid port, expected a number between 1 and 65535`),
+		Entry("empty delimiter", func() { conf.ArtifactNamesDelimiter = "" }, "ARTIFACT_NAMES_DELIMITER: must not be empty"),
+		Entry("unsupported provenance version", func() { conf.Provenance.Version = "v2" }, `PROVENANCE_VERSION: "v2" is not supported, expected v0.2 or v1`),
+		Entry("attach without a provenance path", func() { conf.Provenance.Attach = true }, "PROVENANCE_ATTACH: requires PROVENANCE_PATH to be set"),
+		Entry("invalid build start", func() { conf.BuildStart = "2021-06-01" }, `BUILD_START: "2021-06-01" must be in RFC 3339 format, e.g. 2021-06-01T12:30:00Z`),
+		Entry("build steps outside of GitHub Actions", func() {
+			conf.BuildSteps = "Build"
+			provider = &manualProvider{Repository: "https://github.com/rode/demo-app", CommitId: "123"}
+		}, "BUILD_STEPS: job steps are only available in GitHub Actions, use BUILD_START and BUILD_END instead"),
+		Entry("signed commits outside of GitHub Actions", func() {
+			conf.RequireSignedCommit = true
+			provider = &manualProvider{Repository: "https://github.com/rode/demo-app", CommitId: "123"}
+		}, "REQUIRE_SIGNED_COMMIT: commit signatures are only verified in GitHub Actions"),
+		Entry("workflow scope outside of GitHub Actions", func() {
+			conf.Scope = scopeWorkflow
+			provider = &manualProvider{Repository: "https://github.com/rode/demo-app", CommitId: "123"}
+		}, "SCOPE: the workflow scope is only available in GitHub Actions"),
+		Entry("passphrase without a key", func() { conf.Signing.KeyPassphrase = "secret" }, "SIGNING_KEY_PASSPHRASE: requires SIGNING_KEY to be set"),
+		Entry("invalid artifact", func() { conf.ArtifactId = "" }, "ARTIFACT_ID: either artifactId or artifactType is required"),
+		Entry("slug without a slash", func() { provider.(*githubProvider).config.RepoSlug = "demo-app" }, `GITHUB_REPOSITORY: "demo-app" must be in the form owner/repo`),
+		Entry("unsupported commit source", func() { provider.(*githubProvider).commitSource = "merge" }, `COMMIT_SOURCE: "merge" is not supported, expected sha or head`),
+		Entry("unsupported scope", func() { provider.(*githubProvider).scope = "pipeline" }, `SCOPE: "pipeline" is not supported, expected job or workflow`),
+		Entry("workflow jobs in the job scope", func() { provider.(*githubProvider).workflowJobs = []string{"compile"} }, "WORKFLOW_JOBS: requires SCOPE to be workflow"),
+		Entry("build steps in the workflow scope", func() {
+			provider.(*githubProvider).scope = scopeWorkflow
+			provider.(*githubProvider).buildSteps = []string{"Build"}
+		}, "BUILD_STEPS: can only be used with the job scope, use WORKFLOW_JOBS to select jobs instead"),
+		Entry("server url without a scheme", func() { provider.(*githubProvider).config.ServerUrl = "github.com" }, `GITHUB_SERVER_URL: "github.com" must be an absolute http or https url`),
+		Entry("Pushgateway without a scheme", func() { conf.Metrics.PushgatewayUrl = "pushgateway:9091" }, `METRICS_PUSHGATEWAY_URL: "pushgateway:9091" must be an absolute http or https url`),
+		Entry("trace endpoint without a scheme", func() { conf.Tracing.Endpoint = "otel-collector:4318" }, `TRACING_ENDPOINT: "otel-collector:4318" must be an absolute http or https url`),
+		Entry("policies without a Rode host", func() { conf.Policy.Ids = "harbor-image-policy" }, "POLICY_RODE_HOST: must be set to evaluate POLICY_IDS"),
+		Entry("unsupported policy failure", func() { conf.Policy.Failure = "ignore" }, `POLICY_FAILURE: "ignore" is not supported, expected fail or warn`),
+	)
+
+	It("should report every problem at once", func() {
+		conf.BuildCollector.Host = "rode"
+		conf.Provenance.Version = "v2"
+		provider.(*githubProvider).config.RepoSlug = "rode/demo/app"
+
+		err := validateConfig(conf, provider, true)
+
+		Expect(err).To(HaveOccurred())
+		Expect(err.(configErrors)).To(HaveLen(3))
+		Expect(err.Error()).To(HavePrefix("invalid configuration:\n  BUILD_COLLECTOR_HOST"))
+	})
+
+	It("should allow a token without TLS, e.g. for a build collector in the same cluster", func() {
+		conf.AccessToken = "secret"
+		conf.BuildCollector.Insecure = true
+		conf.Policy = &policyConfig{Ids: "harbor-image-policy", RodeHost: "rode.example.com:50051", RodeInsecure: true, AccessToken: "secret"}
+
+		Expect(validateConfig(conf, provider, true)).To(Succeed())
+	})
+
+	DescribeTable("sending the token in plain text",
+		func(target collectorTarget, expected bool) {
+			Expect(target.plaintextToken()).To(Equal(expected))
+		},
+		Entry("remote without TLS", collectorTarget{Host: "rode-collector-build.rode.svc.cluster.local:8082", Insecure: true, AccessToken: "secret"}, true),
+		Entry("remote with TLS", collectorTarget{Host: "rode-collector-build.example.com:443", AccessToken: "secret"}, false),
+		Entry("local without TLS", collectorTarget{Host: "localhost:8082", Insecure: true, AccessToken: "secret"}, false),
+		Entry("remote without a token", collectorTarget{Host: "rode-collector-build.rode.svc.cluster.local:8082", Insecure: true}, false),
+	)
+
+	When("a build isn't being created", func() {
+		It("should only check the artifact when asked to", func() {
+			conf.ArtifactId = ""
+
+			Expect(validateConfig(conf, nil, false)).To(Succeed())
+			Expect(validateConfig(conf, nil, true)).NotTo(Succeed())
+		})
+	})
+
+	DescribeTable("provider problems",
+		func(provider validator, expected ...string) {
+			Expect(provider.validate()).To(Equal(configErrors(expected)))
+		},
+		Entry("GitLab", &gitlabProvider{config: &gitlabConfig{
+			ApiUrl:       "gitlab.example.com/api/v4",
+			JobUrl:       "https://gitlab.example.com/rode/demo-app/-/jobs/42",
+			ProjectUrl:   "https://gitlab.example.com/rode/demo-app",
+			JobStartedAt: "yesterday",
+		}}, `CI_API_V4_URL: "gitlab.example.com/api/v4" must be an absolute http or https url`, `CI_JOB_STARTED_AT: "yesterday" must be in RFC 3339 format, e.g. 2021-06-01T12:30:00Z`),
+		Entry("Jenkins", &jenkinsProvider{config: &jenkinsConfig{
+			ApiToken: "token",
+			BuildUrl: "https://jenkins.example.com/job/demo-app/12/",
+			GitUrl:   "ftp://example.com/demo-app",
+		}}, `GIT_URL: "ftp://example.com/demo-app" is not a valid git url: unsupported scheme "ftp"`, "JENKINS_API_TOKEN: requires JENKINS_API_USER to be set"),
+		Entry("manual", &manualProvider{LogsUri: "ci.example.com/builds/12"}, "-repository: must be set", "-commit-id: must be set", `-logs-uri: "ci.example.com/builds/12" must be an absolute http or https url`),
+	)
+})